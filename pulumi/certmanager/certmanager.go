@@ -1,17 +1,19 @@
 package certmanager
 
 import (
-	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"uptactics/eks"
+	"uptactics/naming"
+
 	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/yaml"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func CreateCertManager(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
+func CreateCertManager(ctx *pulumi.Context, namer *naming.Namer, cluster *eks.Cluster) error {
 	// Create CertManager from Yaml
-	_, err := yaml.NewConfigFile(ctx, "certmanager", &yaml.ConfigFileArgs{
+	_, err := yaml.NewConfigFile(ctx, namer.Name("certmanager"), &yaml.ConfigFileArgs{
 		File:      "certmanager/cert-manager.yaml",
 		SkipAwait: false,
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	}, pulumi.Provider(cluster.Provider), pulumi.DependsOn([]pulumi.Resource{cluster.Cluster}), naming.Aliases("certmanager"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,101 @@
+package cidr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Parse parses an IPv4 CIDR and rejects host bits and prefixes AWS won't accept for a VPC or subnet
+func Parse(cidr string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip.To4() == nil {
+		return nil, &net.ParseError{Type: "IPv4 CIDR address", Text: cidr}
+	}
+
+	if !ip.Equal(ipNet.IP) {
+		return nil, &net.ParseError{Type: "CIDR address without host bits", Text: cidr}
+	}
+
+	if ones, _ := ipNet.Mask.Size(); ones < 16 || ones > 28 {
+		return nil, &net.ParseError{Type: "CIDR address with a prefix between /16 and /28", Text: cidr}
+	}
+
+	return ipNet, nil
+}
+
+// Contains reports whether inner lies entirely within outer
+func Contains(outer, inner *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+
+	return outer.Contains(inner.IP) && innerOnes >= outerOnes
+}
+
+func Overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Carve allocates one block per prefix length out of base. Blocks are handed out in the
+// order they are requested, each aligned to its own size and placed after the previous
+// one, so the same input always produces the same non-overlapping plan.
+func Carve(base *net.IPNet, prefixLengths []int) ([]*net.IPNet, error) {
+	baseOnes, bits := base.Mask.Size()
+	if bits != 32 {
+		return nil, fmt.Errorf("%s is not an IPv4 CIDR", base)
+	}
+
+	start := uint64(binary.BigEndian.Uint32(base.IP.To4()))
+	end := start + 1<<uint(32-baseOnes)
+	cursor := start
+
+	blocks := make([]*net.IPNet, 0, len(prefixLengths))
+	for _, prefixLength := range prefixLengths {
+		if prefixLength < baseOnes || prefixLength > 32 {
+			return nil, fmt.Errorf("a /%d block cannot be carved out of %s", prefixLength, base)
+		}
+
+		size := uint64(1) << uint(32-prefixLength)
+		blockStart := (cursor + size - 1) / size * size
+		if blockStart+size > end {
+			return nil, fmt.Errorf("%s has no room left for a /%d block", base, prefixLength)
+		}
+
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(blockStart))
+		blocks = append(blocks, &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLength, 32)})
+
+		cursor = blockStart + size
+	}
+
+	return blocks, nil
+}
+
+// Ipv6Subnet returns the index-th /64 of an IPv6 block, e.g. the Amazon-provided /56 of a VPC
+func Ipv6Subnet(block string, index int) (string, error) {
+	ip, ipNet, err := net.ParseCIDR(block)
+	if err != nil {
+		return "", err
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if ip.To4() != nil || bits != 128 || ones > 64 {
+		return "", fmt.Errorf("%s is not an IPv6 block of /64 or larger", block)
+	}
+
+	if index < 0 || uint64(index) >= uint64(1)<<uint(64-ones) {
+		return "", fmt.Errorf("%s has no /64 number %d", block, index)
+	}
+
+	// The /64 number lives in the bits between the block prefix and the 64th bit
+	network := binary.BigEndian.Uint64(ipNet.IP[:8]) | uint64(index)
+
+	subnet := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(subnet[:8], network)
+
+	return (&net.IPNet{IP: subnet, Mask: net.CIDRMask(64, 128)}).String(), nil
+}
@@ -0,0 +1,187 @@
+package cidr
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCarve(t *testing.T) {
+	tests := []struct {
+		name          string
+		base          string
+		prefixLengths []int
+		want          []string
+		wantErr       bool
+	}{
+		{
+			name:          "equal sized blocks are packed back to back",
+			base:          "10.0.0.0/16",
+			prefixLengths: []int{24, 24, 24, 24},
+			want:          []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"},
+		},
+		{
+			name:          "larger blocks are aligned to their own size",
+			base:          "10.0.0.0/16",
+			prefixLengths: []int{24, 24, 20, 20},
+			want:          []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.16.0/20", "10.0.32.0/20"},
+		},
+		{
+			name:          "smaller blocks fill in after larger ones",
+			base:          "10.0.0.0/16",
+			prefixLengths: []int{20, 20, 26, 26},
+			want:          []string{"10.0.0.0/20", "10.0.16.0/20", "10.0.32.0/26", "10.0.32.64/26"},
+		},
+		{
+			name:          "base not starting at zero",
+			base:          "172.16.64.0/18",
+			prefixLengths: []int{19, 20},
+			want:          []string{"172.16.64.0/19", "172.16.96.0/20"},
+		},
+		{
+			name:          "block as large as the base",
+			base:          "10.1.0.0/24",
+			prefixLengths: []int{24},
+			want:          []string{"10.1.0.0/24"},
+		},
+		{
+			name:          "subnets of a tier block",
+			base:          "10.0.4.0/22",
+			prefixLengths: []int{24, 24},
+			want:          []string{"10.0.4.0/24", "10.0.5.0/24"},
+		},
+		{
+			name:          "appending an AZ to a tier block keeps the existing blocks",
+			base:          "10.0.4.0/22",
+			prefixLengths: []int{24, 24, 24},
+			want:          []string{"10.0.4.0/24", "10.0.5.0/24", "10.0.6.0/24"},
+		},
+		{
+			name:          "no blocks",
+			base:          "10.0.0.0/16",
+			prefixLengths: nil,
+			want:          []string{},
+		},
+		{
+			name:          "block larger than the base",
+			base:          "10.0.0.0/24",
+			prefixLengths: []int{23},
+			wantErr:       true,
+		},
+		{
+			name:          "blocks overflow the base",
+			base:          "10.0.0.0/24",
+			prefixLengths: []int{25, 26, 25},
+			wantErr:       true,
+		},
+		{
+			name:          "invalid prefix length",
+			base:          "10.0.0.0/16",
+			prefixLengths: []int{33},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, base, err := net.ParseCIDR(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			blocks, err := Carve(base, tt.prefixLengths)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Carve(%s, %v) = %v, want error", tt.base, tt.prefixLengths, blocks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Carve(%s, %v) returned error: %s", tt.base, tt.prefixLengths, err)
+			}
+
+			got := make([]string, len(blocks))
+			for i, block := range blocks {
+				got[i] = block.String()
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Carve(%s, %v) = %v, want %v", tt.base, tt.prefixLengths, got, tt.want)
+			}
+
+			for i := range blocks {
+				if !Contains(base, blocks[i]) {
+					t.Errorf("block %s is outside %s", blocks[i], base)
+				}
+				for j := 0; j < i; j++ {
+					if Overlaps(blocks[i], blocks[j]) {
+						t.Errorf("block %s overlaps %s", blocks[i], blocks[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		wantErr bool
+	}{
+		{cidr: "10.0.0.0/16"},
+		{cidr: "10.0.1.0/24"},
+		{cidr: "10.0.1.16/28"},
+		{cidr: "10.0.1.5/24", wantErr: true},
+		{cidr: "10.0.0.0/8", wantErr: true},
+		{cidr: "10.0.0.0/29", wantErr: true},
+		{cidr: "2600:1f18::/56", wantErr: true},
+		{cidr: "10.0.0.0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			_, err := Parse(tt.cidr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, wantErr %v", tt.cidr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIpv6Subnet(t *testing.T) {
+	tests := []struct {
+		block   string
+		index   int
+		want    string
+		wantErr bool
+	}{
+		{block: "2600:1f18:abc:de00::/56", index: 0, want: "2600:1f18:abc:de00::/64"},
+		{block: "2600:1f18:abc:de00::/56", index: 1, want: "2600:1f18:abc:de01::/64"},
+		{block: "2600:1f18:abc:de00::/56", index: 255, want: "2600:1f18:abc:deff::/64"},
+		{block: "2600:1f18:abc::/48", index: 4096, want: "2600:1f18:abc:1000::/64"},
+		{block: "2600:1f18:abc:de00::/64", index: 0, want: "2600:1f18:abc:de00::/64"},
+		{block: "2600:1f18:abc:de00::/56", index: 256, wantErr: true},
+		{block: "2600:1f18:abc:de00::/56", index: -1, wantErr: true},
+		{block: "2600:1f18:abc:de00::/72", index: 0, wantErr: true},
+		{block: "10.0.0.0/16", index: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s#%d", tt.block, tt.index), func(t *testing.T) {
+			got, err := Ipv6Subnet(tt.block, tt.index)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Ipv6Subnet(%q, %d) = %q, want error", tt.block, tt.index, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Ipv6Subnet(%q, %d) returned error: %s", tt.block, tt.index, err)
+			}
+			if got != tt.want {
+				t.Errorf("Ipv6Subnet(%q, %d) = %q, want %q", tt.block, tt.index, got, tt.want)
+			}
+		})
+	}
+}
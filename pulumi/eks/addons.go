@@ -0,0 +1,85 @@
+package eks
+
+import (
+	"encoding/json"
+	"strings"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createAddons installs the configured EKS add-ons, keyed by add-on name. They wait for dependencies,
+// the Fargate profiles and node groups, so their pods have somewhere to run when EKS checks their health.
+func createAddons(ctx *pulumi.Context, cfg *stackconfig.StackConfig, serviceAccountRoles *ServiceAccountRoleFactory,
+	dependencies []pulumi.Resource) (map[string]*eks.Addon, error) {
+	addons := map[string]*eks.Addon{}
+	addonVersions := pulumi.StringMap{}
+
+	for _, addonConfig := range cfg.Cluster.Addons {
+		addonName := cfg.Namer.Name("k8s", "addon", addonConfig.Name)
+
+		addonArgs := &eks.AddonArgs{
+			ClusterName:      pulumi.String(cfg.Cluster.Name),
+			AddonName:        pulumi.String(addonConfig.Name),
+			ResolveConflicts: pulumi.String(strings.ToUpper(string(addonConfig.ResolveConflicts))),
+			// Removing an add-on from the list shouldn't take down networking or DNS with it
+			Preserve: pulumi.Bool(true),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(addonName),
+			},
+		}
+
+		switch addonConfig.Version {
+		case stackconfig.AddonVersionDefault, stackconfig.AddonVersionLatest:
+			version, err := eks.GetAddonVersion(ctx, &eks.GetAddonVersionArgs{
+				AddonName:         addonConfig.Name,
+				KubernetesVersion: cfg.Cluster.Version,
+				MostRecent:        pulumi.BoolRef(addonConfig.Version == stackconfig.AddonVersionLatest),
+			})
+			if err != nil {
+				return nil, err
+			}
+			addonArgs.AddonVersion = pulumi.String(version.Version)
+		default:
+			addonArgs.AddonVersion = pulumi.String(addonConfig.Version)
+		}
+
+		if addonConfig.ConfigurationValues != nil {
+			configurationValues, err := json.Marshal(addonConfig.ConfigurationValues)
+			if err != nil {
+				return nil, err
+			}
+			addonArgs.ConfigurationValues = pulumi.String(string(configurationValues))
+		}
+
+		if addonConfig.ServiceAccountRoleArn != "" {
+			addonArgs.ServiceAccountRoleArn = pulumi.String(addonConfig.ServiceAccountRoleArn)
+		}
+
+		// Create IRSA Role
+		if serviceAccount := addonConfig.ServiceAccount; serviceAccount != nil {
+			serviceAccountRole, err := serviceAccountRoles.NewRole(ctx, ServiceAccountRoleArgs{
+				Namespace:      serviceAccount.Namespace,
+				ServiceAccount: serviceAccount.Name,
+				PolicyArns:     serviceAccount.PolicyArns,
+			})
+			if err != nil {
+				return nil, err
+			}
+			addonArgs.ServiceAccountRoleArn = serviceAccountRole.Role.Arn
+		}
+
+		addon, err := eks.NewAddon(ctx, addonName, addonArgs, pulumi.DependsOn(dependencies))
+		if err != nil {
+			return nil, err
+		}
+		addons[addonConfig.Name] = addon
+		addonVersions[addonConfig.Name] = addon.AddonVersion
+	}
+
+	ctx.Export("addonVersions", addonVersions)
+
+	return addons, nil
+}
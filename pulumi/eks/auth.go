@@ -0,0 +1,113 @@
+package eks
+
+import (
+	"encoding/json"
+	"strings"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// roleMapping and userMapping are the entries of the mapRoles and mapUsers keys of aws-auth, see
+// https://docs.aws.amazon.com/eks/latest/userguide/add-user-role.html
+type roleMapping struct {
+	RoleArn  string   `json:"rolearn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+type userMapping struct {
+	UserArn  string   `json:"userarn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// The entries EKS itself adds to aws-auth for the roles of managed node groups and Fargate profiles
+var (
+	nodeRoleMapping = roleMapping{
+		Username: "system:node:{{EC2PrivateDNSName}}",
+		Groups:   []string{"system:bootstrappers", "system:nodes"},
+	}
+	fargateRoleMapping = roleMapping{
+		Username: "system:node:{{SessionName}}",
+		Groups:   []string{"system:bootstrappers", "system:nodes", "system:node-proxier"},
+	}
+)
+
+// awsAuthRoleArn drops the path of a role ARN, aws-auth only matches role ARNs without one
+func awsAuthRoleArn(arn string) string {
+	prefixEnd := strings.Index(arn, ":role/") + len(":role/")
+	return arn[:prefixEnd] + arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// manageAwsAuth takes over the aws-auth ConfigMap. EKS creates it with the node and Fargate roles when the
+// first node group or Fargate profile is created, so the patch waits for them and keeps their entries next
+// to the configured IAM roles and users. The keys hold JSON, which is valid YAML.
+func manageAwsAuth(ctx *pulumi.Context, cfg *stackconfig.StackConfig, provider *kubernetes.Provider,
+	nodeGroups map[string]*eks.NodeGroup, fargateRole *iam.Role, dependencies []pulumi.Resource) error {
+	// Node groups in the order they are configured, so the ConfigMap doesn't change between runs
+	roleArns := []interface{}{fargateRole.Arn}
+	for _, nodeGroupConfig := range cfg.Cluster.NodeGroups {
+		roleArns = append(roleArns, nodeGroups[nodeGroupConfig.Name].NodeRoleArn)
+	}
+
+	mapRoles := pulumi.All(roleArns...).ApplyT(func(arns []interface{}) (string, error) {
+		roles := []roleMapping{}
+
+		fargateRole := fargateRoleMapping
+		fargateRole.RoleArn = awsAuthRoleArn(arns[0].(string))
+		roles = append(roles, fargateRole)
+
+		for _, arn := range arns[1:] {
+			nodeRole := nodeRoleMapping
+			nodeRole.RoleArn = awsAuthRoleArn(arn.(string))
+			roles = append(roles, nodeRole)
+		}
+
+		for _, role := range cfg.Cluster.Access.Roles {
+			roles = append(roles, roleMapping{
+				RoleArn:  awsAuthRoleArn(role.Arn),
+				Username: role.Username,
+				Groups:   role.Groups,
+			})
+		}
+
+		data, err := json.Marshal(roles)
+		return string(data), err
+	}).(pulumi.StringOutput)
+
+	users := []userMapping{}
+	for _, user := range cfg.Cluster.Access.Users {
+		users = append(users, userMapping{
+			UserArn:  user.Arn,
+			Username: user.Username,
+			Groups:   user.Groups,
+		})
+	}
+	mapUsers, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+
+	_, err = corev1.NewConfigMapPatch(ctx, cfg.Namer.Name("k8s", "aws-auth"), &corev1.ConfigMapPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String("aws-auth"),
+			Namespace: pulumi.String("kube-system"),
+			Annotations: pulumi.StringMap{
+				"pulumi.com/patchForce": pulumi.String("true"),
+			},
+		},
+		Data: pulumi.StringMap{
+			"mapRoles": mapRoles,
+			"mapUsers": pulumi.String(string(mapUsers)),
+		},
+	}, pulumi.Provider(provider), pulumi.DependsOn(dependencies))
+
+	return err
+}
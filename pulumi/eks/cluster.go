@@ -0,0 +1,19 @@
+package eks
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Cluster is what the rest of the program gets to know about the EKS cluster
+type Cluster struct {
+	Cluster      *eks.Cluster
+	OidcProvider *iam.OpenIdConnectProvider
+	// ServiceAccountRoles creates IAM roles for the cluster's service accounts
+	ServiceAccountRoles *ServiceAccountRoleFactory
+	Kubeconfig          pulumi.StringOutput
+	// Provider deploys to this cluster, every Kubernetes resource has to be created with it
+	Provider *kubernetes.Provider
+}
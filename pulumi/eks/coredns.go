@@ -0,0 +1,39 @@
+package eks
+
+import (
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apps/v1"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// patchCoreDNSForFargate lets CoreDNS run on Fargate. EKS creates the CoreDNS deployment with the
+// eks.amazonaws.com/compute-type: ec2 annotation, which keeps the Fargate webhook from scheduling its pods.
+// A server-side apply patch can't remove a field owned by EKS, so the annotation is taken over and set to
+// fargate instead, which the webhook treats like a missing annotation. Changing it rolls the pods onto
+// Fargate, so the patch waits for the profile selecting kube-system.
+func patchCoreDNSForFargate(ctx *pulumi.Context, cfg *stackconfig.StackConfig, provider *kubernetes.Provider, kubeSystemProfile pulumi.Resource) error {
+	_, err := appsv1.NewDeploymentPatch(ctx, cfg.Namer.Name("k8s", "coredns"), &appsv1.DeploymentPatchArgs{
+		Metadata: &metav1.ObjectMetaPatchArgs{
+			Name:      pulumi.String("coredns"),
+			Namespace: pulumi.String("kube-system"),
+			Annotations: pulumi.StringMap{
+				"pulumi.com/patchForce": pulumi.String("true"),
+			},
+		},
+		Spec: &appsv1.DeploymentSpecPatchArgs{
+			Template: &corev1.PodTemplateSpecPatchArgs{
+				Metadata: &metav1.ObjectMetaPatchArgs{
+					Annotations: pulumi.StringMap{
+						"eks.amazonaws.com/compute-type": pulumi.String("fargate"),
+					},
+				},
+			},
+		},
+	}, pulumi.Provider(provider), pulumi.DependsOn([]pulumi.Resource{kubeSystemProfile}))
+
+	return err
+}
@@ -3,18 +3,35 @@ package eks
 import (
 	"fmt"
 
+	k8s "uptactics/kubernetes"
+	"uptactics/naming"
+	"uptactics/stackconfig"
+	"uptactics/vpc"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func CreateInfrastructure(ctx *pulumi.Context, vpcId pulumi.StringInput, privateSubnetIds []pulumi.StringInput, publicSubnetIds []pulumi.StringInput) (*eks.Cluster, error) {
-	conf := config.New(ctx, "")
-	clusterName := conf.Require("clusterName")
-	clusterRole := conf.Require("clusterRole")
-	clusterVersion := conf.Require("clusterVersion")
+// managedPolicyArn is the ARN of an AWS managed policy in the partition the stack is deployed to
+func managedPolicyArn(partition, policy string) string {
+	return fmt.Sprintf("arn:%s:iam::aws:policy/%s", partition, policy)
+}
+
+// CreateInfrastructure creates the EKS cluster with its Fargate profiles, node groups and add-ons
+func CreateInfrastructure(ctx *pulumi.Context, cfg *stackconfig.StackConfig, network *vpc.Network) (*Cluster, error) {
+	namer := cfg.Namer
+	clusterName := cfg.Cluster.Name
+	clusterRole := namer.Name("k8s", "cluster", "role")
+
+	partition, err := aws.GetPartition(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	clusterVersion := cfg.Cluster.Version
 
 	// Create EKS Role
 	eksRole, err := iam.NewRole(ctx, clusterRole, &iam.RoleArgs{
@@ -39,15 +56,15 @@ func CreateInfrastructure(ctx *pulumi.Context, vpcId pulumi.StringInput, private
 
 	// Create EKS Policy Attachments
 	eksPolicies := []string{
-		"arn:aws:iam::aws:policy/AmazonEKSServicePolicy",
-		"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy",
-		"arn:aws:iam::aws:policy/AmazonEKSVPCResourceController",
+		"AmazonEKSServicePolicy",
+		"AmazonEKSClusterPolicy",
+		"AmazonEKSVPCResourceController",
 	}
 
 	for i, eksPolicy := range eksPolicies {
 		attachmentName := fmt.Sprintf("%s-rpa-%d", clusterRole, i+1)
 		_, err := iam.NewRolePolicyAttachment(ctx, attachmentName, &iam.RolePolicyAttachmentArgs{
-			PolicyArn: pulumi.String(eksPolicy),
+			PolicyArn: pulumi.String(managedPolicyArn(partition.Partition, eksPolicy)),
 			Role:      eksRole.Name,
 		})
 		if err != nil {
@@ -55,9 +72,29 @@ func CreateInfrastructure(ctx *pulumi.Context, vpcId pulumi.StringInput, private
 		}
 	}
 
-	// Create a Security Group that we can use to actually connect to our cluster
-	additionalSg, err := ec2.NewSecurityGroup(ctx, "cluster-sg", &ec2.SecurityGroupArgs{
-		VpcId: vpcId,
+	// Create a Security Group that we can use to actually connect to our cluster.
+	// The private endpoint accepts HTTPS from the VPC, or the configured CIDRs.
+	endpointAccess := cfg.Cluster.EndpointAccess
+
+	ingress := ec2.SecurityGroupIngressArray{}
+	if endpointAccess.PrivateAccess {
+		privateAccessCidrs := pulumi.StringArray{network.Vpc.CidrBlock}
+		if len(endpointAccess.PrivateAccessCidrs) > 0 {
+			privateAccessCidrs = pulumi.ToStringArray(endpointAccess.PrivateAccessCidrs)
+		}
+
+		ingress = append(ingress, ec2.SecurityGroupIngressArgs{
+			Description: pulumi.String("Kubernetes API"),
+			Protocol:    pulumi.String("tcp"),
+			FromPort:    pulumi.Int(443),
+			ToPort:      pulumi.Int(443),
+			CidrBlocks:  privateAccessCidrs,
+		})
+	}
+
+	additionalSg, err := ec2.NewSecurityGroup(ctx, namer.Name("k8s", "cluster", "sg"), &ec2.SecurityGroupArgs{
+		VpcId:   network.Vpc.ID(),
+		Ingress: ingress,
 		Egress: ec2.SecurityGroupEgressArray{
 			ec2.SecurityGroupEgressArgs{
 				Protocol:   pulumi.String("-1"),
@@ -66,43 +103,112 @@ func CreateInfrastructure(ctx *pulumi.Context, vpcId pulumi.StringInput, private
 				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
 			},
 		},
-	})
+	}, naming.Aliases("cluster-sg"))
 	if err != nil {
 		return nil, err
 	}
 
+	// Create KMS Key for envelope encryption of Secrets
+	secretsKeyArn, err := createSecretsKey(ctx, cfg, partition.Partition, eksRole)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Export("secretsKmsKeyArn", secretsKeyArn)
+
+	// Create the Control Plane Log Group before EKS creates it without a retention
+	clusterOpts := []pulumi.ResourceOption{}
+	enabledLogTypes := pulumi.StringArray{}
+
+	if logging := cfg.Cluster.Logging; logging != nil {
+		logGroupArgs := &cloudwatch.LogGroupArgs{
+			Name:            pulumi.String(fmt.Sprintf("/aws/eks/%s/cluster", clusterName)),
+			RetentionInDays: pulumi.Int(logging.RetentionDays),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(namer.Name("k8s", "cluster", "logs")),
+			},
+		}
+		if logging.KmsKeyArn != "" {
+			logGroupArgs.KmsKeyId = pulumi.String(logging.KmsKeyArn)
+		}
+
+		logGroup, err := cloudwatch.NewLogGroup(ctx, namer.Name("k8s", "cluster", "logs"), logGroupArgs)
+		if err != nil {
+			return nil, err
+		}
+		clusterOpts = append(clusterOpts, pulumi.DependsOn([]pulumi.Resource{logGroup}))
+
+		enabledLogTypes = pulumi.ToStringArray(logging.Types)
+	}
+
 	// Create EKS Control Plane
 	eksCluster, err := eks.NewCluster(ctx, clusterName, &eks.ClusterArgs{
 		Name:    pulumi.String(clusterName),
 		RoleArn: pulumi.StringInput(eksRole.Arn),
 		Version: pulumi.String(clusterVersion),
 
+		EnabledClusterLogTypes: enabledLogTypes,
+
 		VpcConfig: &eks.ClusterVpcConfigArgs{
-			EndpointPrivateAccess: pulumi.Bool(false),
-			EndpointPublicAccess:  pulumi.Bool(true),
-			PublicAccessCidrs: pulumi.StringArray{
-				pulumi.String("0.0.0.0/0"),
-			},
+			EndpointPrivateAccess: pulumi.Bool(endpointAccess.PrivateAccess),
+			EndpointPublicAccess:  pulumi.Bool(endpointAccess.PublicAccess),
+			PublicAccessCidrs:     pulumi.ToStringArray(endpointAccess.PublicAccessCidrs),
 			SecurityGroupIds: pulumi.StringArray{
 				additionalSg.ID().ToStringOutput(),
 			},
-			SubnetIds: pulumi.StringArray(append(privateSubnetIds, publicSubnetIds...)),
+			SubnetIds: append(network.SubnetIds(stackconfig.TierPrivate), network.SubnetIds(stackconfig.TierPublic)...),
+		},
+
+		EncryptionConfig: &eks.ClusterEncryptionConfigArgs{
+			Resources: pulumi.StringArray{pulumi.String("secrets")},
+			Provider: &eks.ClusterEncryptionConfigProviderArgs{
+				KeyArn: secretsKeyArn,
+			},
 		},
 
 		Tags: pulumi.StringMap{
 			"Name": pulumi.String(clusterName),
 		},
-	})
+	}, clusterOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Export kubeconfig. It holds no credentials, but it is marked secret to keep the endpoint out of logs.
+	kubeconfig, err := generateKubeconfig(ctx, cfg, eksCluster)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Export("kubeconfig", pulumi.ToSecret(kubeconfig))
+
+	// Create Kubernetes Provider from the kubeconfig
+	k8sProvider, err := k8s.InitializeKubernetesProvider(ctx, namer, kubeconfig)
 	if err != nil {
 		return nil, err
 	}
 
-	// Export kubeconfig
-	ctx.Export("kubeconfig", generateKubeconfig(eksCluster.Endpoint,
-		eksCluster.CertificateAuthority.Data().Elem(), eksCluster.Name))
+	// Without the public endpoint the API is only reachable from networks routed to the VPC
+	if !endpointAccess.PublicAccess {
+		ctx.Export("clusterApiAccess", pulumi.Sprintf("The API endpoint %s is private to %s. Reach it through a bastion host, "+
+			"e.g. the one in uptactics/bastion.tf: sshuttle -r ubuntu@<bastion public IP> %s", eksCluster.Endpoint, network.Vpc.ID(), network.Vpc.CidrBlock))
+	}
+
+	// Create OIDC Provider so service accounts can assume IAM roles
+	oidcProvider, err := createOidcProvider(ctx, namer, eksCluster)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Export("oidcProviderArn", oidcProvider.Arn)
+
+	cluster := &Cluster{
+		Cluster:             eksCluster,
+		OidcProvider:        oidcProvider,
+		ServiceAccountRoles: &ServiceAccountRoleFactory{namer: namer, provider: oidcProvider},
+		Kubeconfig:          kubeconfig,
+		Provider:            k8sProvider,
+	}
 
 	// Create Fargate Profile Role
-	fargateRoleName := conf.Require("fargateRoleName")
+	fargateRoleName := namer.Name("k8s", "fargate", "role")
 	fargateRole, err := iam.NewRole(ctx, fargateRoleName, &iam.RoleArgs{
 		Name: pulumi.String(fargateRoleName),
 		AssumeRolePolicy: pulumi.String(`{
@@ -126,98 +232,54 @@ func CreateInfrastructure(ctx *pulumi.Context, vpcId pulumi.StringInput, private
 	// Create Farget Policy Attachment
 	attachmentName := fmt.Sprintf("%s-rpa", fargateRoleName)
 	_, err = iam.NewRolePolicyAttachment(ctx, attachmentName, &iam.RolePolicyAttachmentArgs{
-		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonEKSFargatePodExecutionRolePolicy"),
+		PolicyArn: pulumi.String(managedPolicyArn(partition.Partition, "AmazonEKSFargatePodExecutionRolePolicy")),
 		Role:      fargateRole.Name,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Create AWS Fargate Profile
+	// Create Fargate Profiles
+	fargateProfiles, err := createFargateProfiles(ctx, cfg, network, eksCluster, fargateRole)
+	if err != nil {
+		return nil, err
+	}
 
-	fargateProfileName := conf.Require("fargateProfileName")
-	_, err = eks.NewFargateProfile(ctx, fargateProfileName, &eks.FargateProfileArgs{
-		ClusterName:         pulumi.String(clusterName),
-		FargateProfileName:  pulumi.String(fargateProfileName),
-		PodExecutionRoleArn: pulumi.StringInput(fargateRole.Arn),
-		SubnetIds:           pulumi.StringArray(privateSubnetIds),
-		Selectors: eks.FargateProfileSelectorArray{
-			eks.FargateProfileSelectorArgs{
-				Namespace: pulumi.String("kube-system"),
-			},
-			eks.FargateProfileSelectorArgs{
-				Namespace: pulumi.String("default"),
-			},
-		},
-		Tags: pulumi.StringMap{
-			"Name": pulumi.String(fargateProfileName),
-		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	// Create Managed Node Groups
+	nodeGroups, err := createNodeGroups(ctx, cfg, partition.Partition, network, eksCluster)
 	if err != nil {
 		return nil, err
 	}
 
-	fargateProfileAppsName := conf.Require("fargateProfileName") + "-apps"
-	_, err = eks.NewFargateProfile(ctx, fargateProfileAppsName, &eks.FargateProfileArgs{
-		ClusterName:         pulumi.String(clusterName),
-		FargateProfileName:  pulumi.String(fargateProfileAppsName),
-		PodExecutionRoleArn: pulumi.StringInput(fargateRole.Arn),
-		SubnetIds:           pulumi.StringArray(privateSubnetIds),
-		Selectors: eks.FargateProfileSelectorArray{
-			eks.FargateProfileSelectorArgs{
-				Namespace: pulumi.String("traefik"),
-			},
-			eks.FargateProfileSelectorArgs{
-				Namespace: pulumi.String("cert-manager"),
-			},
-			eks.FargateProfileSelectorArgs{
-				Namespace: pulumi.String("apps-*"),
-			},
-		},
-		Tags: pulumi.StringMap{
-			"Name": pulumi.String(fargateProfileAppsName),
-		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	// Create Add-ons once there is compute for their pods
+	computeResources := []pulumi.Resource{}
+	for _, fargateProfile := range fargateProfiles {
+		computeResources = append(computeResources, fargateProfile)
+	}
+	for _, nodeGroup := range nodeGroups {
+		computeResources = append(computeResources, nodeGroup)
+	}
+
+	addons, err := createAddons(ctx, cfg, cluster.ServiceAccountRoles, computeResources)
 	if err != nil {
 		return nil, err
 	}
 
-	return eksCluster, nil
-}
+	// Map the team's IAM principals into the cluster next to the node roles
+	err = manageAwsAuth(ctx, cfg, k8sProvider, nodeGroups, fargateRole, computeResources)
+	if err != nil {
+		return nil, err
+	}
+
+	// Move CoreDNS onto the profile selecting it. The CoreDNS add-on would undo the patch, with the
+	// add-on CoreDNS is moved to Fargate through its configurationValues instead.
+	coreDNSProfile := cfg.Cluster.CoreDNSFargateProfile()
+	if _, ok := addons["coredns"]; !ok && coreDNSProfile != "" {
+		err = patchCoreDNSForFargate(ctx, cfg, k8sProvider, fargateProfiles[coreDNSProfile])
+		if err != nil {
+			return nil, err
+		}
+	}
 
-// Create the KubeConfig Structure as per https://docs.aws.amazon.com/eks/latest/userguide/create-kubeconfig.html
-func generateKubeconfig(clusterEndpoint pulumi.StringOutput, certData pulumi.StringOutput, clusterName pulumi.StringOutput) pulumi.StringOutput {
-	return pulumi.Sprintf(`{
-        "apiVersion": "v1",
-        "clusters": [{
-            "cluster": {
-                "server": "%s",
-                "certificate-authority-data": "%s"
-            },
-            "name": "kubernetes",
-        }],
-        "contexts": [{
-            "context": {
-                "cluster": "kubernetes",
-                "user": "aws",
-            },
-            "name": "aws",
-        }],
-        "current-context": "aws",
-        "kind": "Config",
-        "users": [{
-            "name": "aws",
-            "user": {
-                "exec": {
-                    "apiVersion": "client.authentication.k8s.io/v1alpha1",
-                    "command": "aws-iam-authenticator",
-                    "args": [
-                        "token",
-                        "-i",
-                        "%s",
-                    ],
-                },
-            },
-        }],
-    }`, clusterEndpoint, certData, clusterName)
+	return cluster, nil
 }
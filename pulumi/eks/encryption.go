@@ -0,0 +1,106 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/kms"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// What the cluster role needs to envelope encrypt Secrets with a key
+var secretsKeyActions = []string{"kms:Encrypt", "kms:Decrypt", "kms:ListGrants", "kms:DescribeKey"}
+
+// createSecretsKey returns the ARN of the KMS key Kubernetes Secrets are encrypted with. Unless an existing key
+// is configured, a key with rotation is created whose policy grants the cluster role its use. Either way the
+// cluster role gets an inline policy for the key, which is what grants access to a key whose policy defers to IAM.
+func createSecretsKey(ctx *pulumi.Context, cfg *stackconfig.StackConfig, partition string, eksRole *iam.Role) (pulumi.StringOutput, error) {
+	keyName := cfg.Namer.Name("k8s", "secrets")
+	keyArn := pulumi.String(cfg.Cluster.SecretsKmsKeyArn).ToStringOutput()
+
+	if cfg.Cluster.SecretsKmsKeyArn == "" {
+		caller, err := aws.GetCallerIdentity(ctx, nil)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+
+		// The account keeps full control of the key, so it can't be locked out
+		keyPolicy := eksRole.Arn.ApplyT(func(roleArn string) (string, error) {
+			policy, err := json.Marshal(map[string]interface{}{
+				"Version": "2012-10-17",
+				"Statement": []map[string]interface{}{
+					{
+						"Sid":       "AccountAdministration",
+						"Effect":    "Allow",
+						"Principal": map[string]string{"AWS": fmt.Sprintf("arn:%s:iam::%s:root", partition, caller.AccountId)},
+						"Action":    "kms:*",
+						"Resource":  "*",
+					},
+					{
+						"Sid":       "ClusterSecretsEncryption",
+						"Effect":    "Allow",
+						"Principal": map[string]string{"AWS": roleArn},
+						"Action":    secretsKeyActions,
+						"Resource":  "*",
+					},
+				},
+			})
+
+			return string(policy), err
+		}).(pulumi.StringOutput)
+
+		key, err := kms.NewKey(ctx, keyName, &kms.KeyArgs{
+			Description:       pulumi.String(fmt.Sprintf("Envelope encryption of the Secrets of %s", cfg.Cluster.Name)),
+			EnableKeyRotation: pulumi.Bool(true),
+			Policy:            keyPolicy,
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(keyName),
+			},
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+
+		_, err = kms.NewAlias(ctx, keyName, &kms.AliasArgs{
+			Name:        pulumi.String("alias/" + keyName),
+			TargetKeyId: key.KeyId,
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+
+		keyArn = key.Arn
+	}
+
+	rolePolicy := keyArn.ApplyT(func(arn string) (string, error) {
+		policy, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect":   "Allow",
+					"Action":   secretsKeyActions,
+					"Resource": arn,
+				},
+			},
+		})
+
+		return string(policy), err
+	}).(pulumi.StringOutput)
+
+	keyRolePolicy, err := iam.NewRolePolicy(ctx, keyName, &iam.RolePolicyArgs{
+		Role:   eksRole.Name,
+		Policy: rolePolicy,
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	// Hand out the ARN only once the cluster role may use the key, EKS checks that when encryption is enabled
+	return pulumi.All(keyArn, keyRolePolicy.ID()).ApplyT(func(values []interface{}) string {
+		return values[0].(string)
+	}).(pulumi.StringOutput), nil
+}
@@ -0,0 +1,56 @@
+package eks
+
+import (
+	"fmt"
+
+	"uptactics/stackconfig"
+	"uptactics/vpc"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createFargateProfiles creates the configured Fargate profiles, keyed by the name they are configured with
+func createFargateProfiles(ctx *pulumi.Context, cfg *stackconfig.StackConfig, network *vpc.Network, eksCluster *eks.Cluster,
+	fargateRole *iam.Role) (map[string]*eks.FargateProfile, error) {
+	fargateProfiles := map[string]*eks.FargateProfile{}
+
+	for _, profileConfig := range cfg.Cluster.FargateProfiles {
+		profileName := cfg.Namer.Name("k8s", "fargate", profileConfig.Name)
+		if profileConfig.LegacyName != "" {
+			profileName = profileConfig.LegacyName
+		}
+
+		subnetIds, err := network.PlacementSubnetIds(profileConfig.Tier, profileConfig.AZs)
+		if err != nil {
+			return nil, fmt.Errorf("fargate profile %s: %w", profileConfig.Name, err)
+		}
+
+		selectors := eks.FargateProfileSelectorArray{}
+		for _, selector := range profileConfig.Selectors {
+			selectors = append(selectors, eks.FargateProfileSelectorArgs{
+				Namespace: pulumi.String(selector.Namespace),
+				Labels:    pulumi.ToStringMap(selector.Labels),
+			})
+		}
+
+		tags := pulumi.ToStringMap(profileConfig.Tags)
+		tags["Name"] = pulumi.String(profileName)
+
+		fargateProfile, err := eks.NewFargateProfile(ctx, profileName, &eks.FargateProfileArgs{
+			ClusterName:         pulumi.String(cfg.Cluster.Name),
+			FargateProfileName:  pulumi.String(profileName),
+			PodExecutionRoleArn: fargateRole.Arn,
+			SubnetIds:           subnetIds,
+			Selectors:           selectors,
+			Tags:                tags,
+		}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+		if err != nil {
+			return nil, err
+		}
+		fargateProfiles[profileConfig.Name] = fargateProfile
+	}
+
+	return fargateProfiles, nil
+}
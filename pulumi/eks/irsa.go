@@ -0,0 +1,154 @@
+package eks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"uptactics/naming"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Thumbprint of the root CA behind the EKS OIDC issuers, oidc.eks.<region>.amazonaws.com
+const oidcThumbprint = "9e99a48a9960b14926bb7f3b02e22da2b0ab7280"
+
+// ServiceAccountRoleArnAnnotation tells the EKS pod identity webhook which role a service account's pods assume
+const ServiceAccountRoleArnAnnotation = "eks.amazonaws.com/role-arn"
+
+// PolicyStatement is a single statement of an IAM policy
+type PolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// IAM rejects role names longer than this
+const maxRoleNameLength = 64
+
+type ServiceAccountRoleArgs struct {
+	Namespace      string
+	ServiceAccount string
+	// Statements become an inline policy of the role, PolicyArns are attached to it
+	Statements []PolicyStatement
+	PolicyArns []string
+}
+
+// ServiceAccountRole is an IAM role for a service account (IRSA). Annotations go on the ServiceAccount
+// so its pods get credentials for the role.
+type ServiceAccountRole struct {
+	Role        *iam.Role
+	Annotations pulumi.StringMap
+}
+
+// ServiceAccountRoleFactory creates IAM roles that can only be assumed by one service account of the cluster
+type ServiceAccountRoleFactory struct {
+	namer    *naming.Namer
+	provider *iam.OpenIdConnectProvider
+}
+
+// createOidcProvider registers the cluster's OIDC issuer with IAM, which lets service accounts assume IAM roles
+func createOidcProvider(ctx *pulumi.Context, namer *naming.Namer, eksCluster *eks.Cluster) (*iam.OpenIdConnectProvider, error) {
+	oidcName := namer.Name("k8s", "oidc")
+
+	return iam.NewOpenIdConnectProvider(ctx, oidcName, &iam.OpenIdConnectProviderArgs{
+		Url:             eksCluster.Identities.Index(pulumi.Int(0)).Oidcs().Index(pulumi.Int(0)).Issuer().Elem(),
+		ClientIdLists:   pulumi.StringArray{pulumi.String("sts.amazonaws.com")},
+		ThumbprintLists: pulumi.StringArray{pulumi.String(oidcThumbprint)},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(oidcName),
+		},
+	})
+}
+
+// iamRoleName shortens names IAM would reject, keeping them unique with a hash of the full name
+func iamRoleName(name string) string {
+	if len(name) <= maxRoleNameLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:8]
+	return name[:maxRoleNameLength-len(suffix)-1] + "-" + suffix
+}
+
+// NewRole creates a role whose trust policy only lets the given service account assume it
+func (f *ServiceAccountRoleFactory) NewRole(ctx *pulumi.Context, args ServiceAccountRoleArgs) (*ServiceAccountRole, error) {
+	roleName := f.namer.Name("k8s", "irsa", args.Namespace, args.ServiceAccount)
+	subject := fmt.Sprintf("system:serviceaccount:%s:%s", args.Namespace, args.ServiceAccount)
+
+	assumeRolePolicy := pulumi.All(f.provider.Arn, f.provider.Url).ApplyT(func(values []interface{}) (string, error) {
+		providerArn := values[0].(string)
+		issuer := strings.TrimPrefix(values[1].(string), "https://")
+
+		policy, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect":    "Allow",
+					"Principal": map[string]string{"Federated": providerArn},
+					"Action":    "sts:AssumeRoleWithWebIdentity",
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]string{
+							issuer + ":sub": subject,
+							issuer + ":aud": "sts.amazonaws.com",
+						},
+					},
+				},
+			},
+		})
+
+		return string(policy), err
+	}).(pulumi.StringOutput)
+
+	role, err := iam.NewRole(ctx, roleName, &iam.RoleArgs{
+		Name:             pulumi.String(iamRoleName(roleName)),
+		AssumeRolePolicy: assumeRolePolicy,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(roleName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args.Statements) > 0 {
+		policy, err := json.Marshal(map[string]interface{}{
+			"Version":   "2012-10-17",
+			"Statement": args.Statements,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = iam.NewRolePolicy(ctx, roleName, &iam.RolePolicyArgs{
+			Role:   role.Name,
+			Policy: pulumi.String(string(policy)),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, policyArn := range args.PolicyArns {
+		attachmentName := fmt.Sprintf("%s-rpa-%d", roleName, i+1)
+		_, err := iam.NewRolePolicyAttachment(ctx, attachmentName, &iam.RolePolicyAttachmentArgs{
+			PolicyArn: pulumi.String(policyArn),
+			Role:      role.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ServiceAccountRole{
+		Role: role,
+		Annotations: pulumi.StringMap{
+			ServiceAccountRoleArnAnnotation: role.Arn,
+		},
+	}, nil
+}
@@ -0,0 +1,31 @@
+package eks
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIamRoleName(t *testing.T) {
+	short := "u-staging-k8s-irsa-kube-system-aws-load-balancer-controller"
+	if got := iamRoleName(short); got != short {
+		t.Errorf("iamRoleName(%q) = %q, want it unchanged", short, got)
+	}
+
+	long := "u-staging-k8s-irsa-external-secrets-operator-external-secrets-operator-webhook"
+	got := iamRoleName(long)
+	if len(got) != maxRoleNameLength {
+		t.Errorf("iamRoleName(%q) = %q, %d characters, want %d", long, got, len(got), maxRoleNameLength)
+	}
+	if !strings.HasPrefix(got, long[:maxRoleNameLength-9]) {
+		t.Errorf("iamRoleName(%q) = %q, want it to keep the start of the name", long, got)
+	}
+	if got != iamRoleName(long) {
+		t.Errorf("iamRoleName(%q) isn't stable", long)
+	}
+
+	// Names that only differ past the cut still get different roles
+	other := long[:len(long)-1] + "s"
+	if iamRoleName(other) == got {
+		t.Errorf("iamRoleName(%q) = iamRoleName(%q) = %q", other, long, got)
+	}
+}
@@ -0,0 +1,155 @@
+package eks
+
+import (
+	"encoding/json"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// The kubeconfig structure as per https://kubernetes.io/docs/reference/config-api/kubeconfig.v1/,
+// limited to what an EKS kubeconfig uses
+type kubeconfig struct {
+	APIVersion     string         `json:"apiVersion"`
+	Kind           string         `json:"kind"`
+	Clusters       []namedCluster `json:"clusters"`
+	Contexts       []namedContext `json:"contexts"`
+	CurrentContext string         `json:"current-context"`
+	Users          []namedUser    `json:"users"`
+}
+
+type namedCluster struct {
+	Name    string            `json:"name"`
+	Cluster kubeconfigCluster `json:"cluster"`
+}
+
+type kubeconfigCluster struct {
+	Server                   string `json:"server"`
+	CertificateAuthorityData string `json:"certificate-authority-data"`
+}
+
+type namedContext struct {
+	Name    string            `json:"name"`
+	Context kubeconfigContext `json:"context"`
+}
+
+type kubeconfigContext struct {
+	Cluster string `json:"cluster"`
+	User    string `json:"user"`
+}
+
+type namedUser struct {
+	Name string         `json:"name"`
+	User kubeconfigUser `json:"user"`
+}
+
+type kubeconfigUser struct {
+	Exec execConfig `json:"exec"`
+}
+
+type execConfig struct {
+	APIVersion string       `json:"apiVersion"`
+	Command    string       `json:"command"`
+	Args       []string     `json:"args"`
+	Env        []execEnvVar `json:"env,omitempty"`
+}
+
+type execEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// kubeconfigArgs is everything the kubeconfig of a cluster is built from
+type kubeconfigArgs struct {
+	ClusterName              string
+	Endpoint                 string
+	CertificateAuthorityData string
+	Region                   string
+	// RoleArn and Profile are optional, see stackconfig.KubeconfigConfig
+	RoleArn string
+	Profile string
+}
+
+// buildKubeconfig renders a kubeconfig that authenticates with `aws eks get-token`,
+// like the one `aws eks update-kubeconfig` writes
+func buildKubeconfig(args kubeconfigArgs) (string, error) {
+	execArgs := []string{"eks", "get-token", "--cluster-name", args.ClusterName}
+	if args.Region != "" {
+		execArgs = append(execArgs, "--region", args.Region)
+	}
+	if args.RoleArn != "" {
+		execArgs = append(execArgs, "--role-arn", args.RoleArn)
+	}
+
+	env := []execEnvVar{}
+	if args.Profile != "" {
+		env = append(env, execEnvVar{Name: "AWS_PROFILE", Value: args.Profile})
+	}
+
+	config := kubeconfig{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []namedCluster{
+			{
+				Name: args.ClusterName,
+				Cluster: kubeconfigCluster{
+					Server:                   args.Endpoint,
+					CertificateAuthorityData: args.CertificateAuthorityData,
+				},
+			},
+		},
+		Contexts: []namedContext{
+			{
+				Name: args.ClusterName,
+				Context: kubeconfigContext{
+					Cluster: args.ClusterName,
+					User:    args.ClusterName,
+				},
+			},
+		},
+		CurrentContext: args.ClusterName,
+		Users: []namedUser{
+			{
+				Name: args.ClusterName,
+				User: kubeconfigUser{
+					Exec: execConfig{
+						APIVersion: "client.authentication.k8s.io/v1beta1",
+						Command:    "aws",
+						Args:       execArgs,
+						Env:        env,
+					},
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// generateKubeconfig builds the kubeconfig of the cluster once its endpoint and certificate are known
+func generateKubeconfig(ctx *pulumi.Context, cfg *stackconfig.StackConfig, eksCluster *eks.Cluster) (pulumi.StringOutput, error) {
+	region, err := aws.GetRegion(ctx, nil)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	return pulumi.All(eksCluster.Name, eksCluster.Endpoint, eksCluster.CertificateAuthority.Data().Elem()).ApplyT(
+		func(values []interface{}) (string, error) {
+			return buildKubeconfig(kubeconfigArgs{
+				ClusterName:              values[0].(string),
+				Endpoint:                 values[1].(string),
+				CertificateAuthorityData: values[2].(string),
+				Region:                   region.Name,
+				RoleArn:                  cfg.Cluster.Kubeconfig.RoleArn,
+				Profile:                  cfg.Cluster.Kubeconfig.Profile,
+			})
+		}).(pulumi.StringOutput), nil
+}
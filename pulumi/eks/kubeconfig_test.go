@@ -0,0 +1,87 @@
+package eks
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestBuildKubeconfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     kubeconfigArgs
+		wantArgs []string
+		wantEnv  []execEnvVar
+	}{
+		{
+			name: "cluster only",
+			args: kubeconfigArgs{
+				ClusterName:              "u-staging-k8s-cluster",
+				Endpoint:                 "https://0123456789ABCDEF.gr7.us-east-1.eks.amazonaws.com",
+				CertificateAuthorityData: "Y2VydGlmaWNhdGU=",
+			},
+			wantArgs: []string{"eks", "get-token", "--cluster-name", "u-staging-k8s-cluster"},
+		},
+		{
+			name: "region, role and profile",
+			args: kubeconfigArgs{
+				ClusterName:              "u-staging-k8s-cluster",
+				Endpoint:                 "https://0123456789ABCDEF.gr7.us-east-1.eks.amazonaws.com",
+				CertificateAuthorityData: "Y2VydGlmaWNhdGU=",
+				Region:                   "us-east-1",
+				RoleArn:                  "arn:aws:iam::123456789012:role/cluster-admin",
+				Profile:                  "uptactics",
+			},
+			wantArgs: []string{"eks", "get-token", "--cluster-name", "u-staging-k8s-cluster",
+				"--region", "us-east-1", "--role-arn", "arn:aws:iam::123456789012:role/cluster-admin"},
+			wantEnv: []execEnvVar{{Name: "AWS_PROFILE", Value: "uptactics"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := buildKubeconfig(tt.args)
+			if err != nil {
+				t.Fatalf("buildKubeconfig() error = %v", err)
+			}
+
+			var got kubeconfig
+			if err := json.Unmarshal([]byte(data), &got); err != nil {
+				t.Fatalf("buildKubeconfig() is not valid JSON: %v\n%s", err, data)
+			}
+
+			if got.APIVersion != "v1" || got.Kind != "Config" {
+				t.Errorf("apiVersion/kind = %s/%s, want v1/Config", got.APIVersion, got.Kind)
+			}
+
+			if len(got.Clusters) != 1 || len(got.Contexts) != 1 || len(got.Users) != 1 {
+				t.Fatalf("got %d clusters, %d contexts and %d users, want one of each", len(got.Clusters), len(got.Contexts), len(got.Users))
+			}
+
+			cluster := got.Clusters[0]
+			if cluster.Cluster.Server != tt.args.Endpoint || cluster.Cluster.CertificateAuthorityData != tt.args.CertificateAuthorityData {
+				t.Errorf("cluster = %+v, want server %s and certificate %s", cluster.Cluster, tt.args.Endpoint, tt.args.CertificateAuthorityData)
+			}
+
+			context := got.Contexts[0]
+			if got.CurrentContext != context.Name || context.Context.Cluster != cluster.Name || context.Context.User != got.Users[0].Name {
+				t.Errorf("current context %q with %+v doesn't point at cluster %q and user %q",
+					got.CurrentContext, context, cluster.Name, got.Users[0].Name)
+			}
+
+			exec := got.Users[0].User.Exec
+			if exec.APIVersion != "client.authentication.k8s.io/v1beta1" {
+				t.Errorf("exec apiVersion = %s, want client.authentication.k8s.io/v1beta1", exec.APIVersion)
+			}
+			if exec.Command != "aws" {
+				t.Errorf("exec command = %s, want aws", exec.Command)
+			}
+			if !reflect.DeepEqual(exec.Args, tt.wantArgs) {
+				t.Errorf("exec args = %v, want %v", exec.Args, tt.wantArgs)
+			}
+			if !reflect.DeepEqual(exec.Env, tt.wantEnv) {
+				t.Errorf("exec env = %v, want %v", exec.Env, tt.wantEnv)
+			}
+		})
+	}
+}
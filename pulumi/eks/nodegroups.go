@@ -0,0 +1,159 @@
+package eks
+
+import (
+	"fmt"
+
+	"uptactics/stackconfig"
+	"uptactics/vpc"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Managed policies every node needs to join the cluster, run the VPC CNI and pull images from ECR
+var nodePolicies = []string{
+	"AmazonEKSWorkerNodePolicy",
+	"AmazonEKS_CNI_Policy",
+	"AmazonEC2ContainerRegistryReadOnly",
+}
+
+// createNodeGroups creates the configured managed node groups, each with its own node role, keyed by
+// the name they are configured with
+func createNodeGroups(ctx *pulumi.Context, cfg *stackconfig.StackConfig, partition string, network *vpc.Network,
+	eksCluster *eks.Cluster) (map[string]*eks.NodeGroup, error) {
+	nodeGroups := map[string]*eks.NodeGroup{}
+
+	for _, nodeGroupConfig := range cfg.Cluster.NodeGroups {
+		nodeGroup, err := createNodeGroup(ctx, cfg, partition, network, eksCluster, nodeGroupConfig)
+		if err != nil {
+			return nil, err
+		}
+		nodeGroups[nodeGroupConfig.Name] = nodeGroup
+	}
+
+	return nodeGroups, nil
+}
+
+// nodeGroupAmiType picks the EKS optimized Amazon Linux 2 image for the architecture of the instance types,
+// which all have to share it
+func nodeGroupAmiType(ctx *pulumi.Context, nodeGroupConfig stackconfig.NodeGroupConfig) (string, error) {
+	amiTypes := map[string]string{}
+	for _, instanceType := range nodeGroupConfig.InstanceTypes {
+		info, err := ec2.GetInstanceType(ctx, &ec2.GetInstanceTypeArgs{
+			InstanceType: instanceType,
+		})
+		if err != nil {
+			return "", err
+		}
+		amiType := "AL2_x86_64"
+		for _, supported := range info.SupportedArchitectures {
+			if supported == "arm64" {
+				amiType = "AL2_ARM_64"
+			}
+		}
+		amiTypes[amiType] = instanceType
+	}
+
+	if len(amiTypes) > 1 {
+		return "", fmt.Errorf("node group %s mixes arm64 (%s) and x86_64 (%s) instance types, which can't share an image",
+			nodeGroupConfig.Name, amiTypes["AL2_ARM_64"], amiTypes["AL2_x86_64"])
+	}
+	for amiType := range amiTypes {
+		return amiType, nil
+	}
+	return "AL2_x86_64", nil
+}
+
+func createNodeGroup(ctx *pulumi.Context, cfg *stackconfig.StackConfig, partition string, network *vpc.Network,
+	eksCluster *eks.Cluster, nodeGroupConfig stackconfig.NodeGroupConfig) (*eks.NodeGroup, error) {
+	nodeGroupName := cfg.Namer.Name("k8s", "ng", nodeGroupConfig.Name)
+
+	subnetIds, err := network.PlacementSubnetIds(nodeGroupConfig.Tier, nodeGroupConfig.AZs)
+	if err != nil {
+		return nil, fmt.Errorf("node group %s: %w", nodeGroupConfig.Name, err)
+	}
+
+	amiType, err := nodeGroupAmiType(ctx, nodeGroupConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create Node Role
+	nodeRoleName := cfg.Namer.Name("k8s", "ng", nodeGroupConfig.Name, "role")
+	nodeRole, err := iam.NewRole(ctx, nodeRoleName, &iam.RoleArgs{
+		Name: pulumi.String(nodeRoleName),
+		AssumeRolePolicy: pulumi.String(`{
+		    "Version": "2012-10-17",
+		    "Statement": [{
+		        "Effect": "Allow",
+		        "Principal": {
+		            "Service": "ec2.amazonaws.com"
+		        },
+		        "Action": "sts:AssumeRole"
+		    }]
+		}`),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(nodeRoleName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Create Node Policy Attachments. Nodes can't join the cluster without them, so the node group waits for them.
+	dependencies := []pulumi.Resource{eksCluster}
+	for i, nodePolicy := range nodePolicies {
+		attachmentName := fmt.Sprintf("%s-rpa-%d", nodeRoleName, i+1)
+		attachment, err := iam.NewRolePolicyAttachment(ctx, attachmentName, &iam.RolePolicyAttachmentArgs{
+			PolicyArn: pulumi.String(managedPolicyArn(partition, nodePolicy)),
+			Role:      nodeRole.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		dependencies = append(dependencies, attachment)
+	}
+
+	instanceTypes := pulumi.StringArray{}
+	for _, instanceType := range nodeGroupConfig.InstanceTypes {
+		instanceTypes = append(instanceTypes, pulumi.String(instanceType))
+	}
+
+	capacityType := "ON_DEMAND"
+	if nodeGroupConfig.CapacityType == stackconfig.CapacityTypeSpot {
+		capacityType = "SPOT"
+	}
+
+	taints := eks.NodeGroupTaintArray{}
+	for _, taint := range nodeGroupConfig.Taints {
+		taints = append(taints, eks.NodeGroupTaintArgs{
+			Key:    pulumi.String(taint.Key),
+			Value:  pulumi.String(taint.Value),
+			Effect: pulumi.String(stackconfig.TaintEffects[taint.Effect]),
+		})
+	}
+
+	// Create Node Group. The cluster autoscaler owns the desired size once the group exists.
+	return eks.NewNodeGroup(ctx, nodeGroupName, &eks.NodeGroupArgs{
+		ClusterName:   pulumi.String(cfg.Cluster.Name),
+		NodeGroupName: pulumi.String(nodeGroupName),
+		NodeRoleArn:   nodeRole.Arn,
+		SubnetIds:     subnetIds,
+		InstanceTypes: instanceTypes,
+		AmiType:       pulumi.String(amiType),
+		CapacityType:  pulumi.String(capacityType),
+		DiskSize:      pulumi.Int(nodeGroupConfig.DiskSize),
+		ScalingConfig: eks.NodeGroupScalingConfigArgs{
+			MinSize:     pulumi.Int(nodeGroupConfig.MinSize),
+			MaxSize:     pulumi.Int(nodeGroupConfig.MaxSize),
+			DesiredSize: pulumi.Int(*nodeGroupConfig.DesiredSize),
+		},
+		Labels: pulumi.ToStringMap(nodeGroupConfig.Labels),
+		Taints: taints,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(nodeGroupName),
+		},
+	}, pulumi.DependsOn(dependencies), pulumi.IgnoreChanges([]string{"scalingConfig.desiredSize"}))
+}
@@ -1,21 +1,23 @@
 package kubernetes
 
 import (
+	"uptactics/naming"
+
 	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func InitializeKubernetesProvider(ctx *pulumi.Context) (*kubernetes.Provider, error) {
-	conf := config.New(ctx, "")
-	contextName := conf.Require("kubernetesContext")
-
-	provider, err := kubernetes.NewProvider(ctx, contextName, &kubernetes.ProviderArgs{
-		Context: pulumi.String(contextName),
+// InitializeKubernetesProvider creates a provider for the cluster the kubeconfig points at. Every Kubernetes
+// resource is created with it, so nothing depends on the kubectl context of whoever runs the program.
+func InitializeKubernetesProvider(ctx *pulumi.Context, namer *naming.Namer, kubeconfig pulumi.StringInput) (*kubernetes.Provider, error) {
+	provider, err := kubernetes.NewProvider(ctx, namer.Name("k8s", "provider"), &kubernetes.ProviderArgs{
+		Kubeconfig: kubeconfig,
+		// Patch resources, like the CoreDNS one, need server-side apply
+		EnableServerSideApply: pulumi.Bool(true),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-  return provider, nil
+	return provider, nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"uptactics/certmanager"
 	"uptactics/eks"
+	"uptactics/stackconfig"
 	"uptactics/traefik"
 	"uptactics/vpc"
 
@@ -11,22 +12,27 @@ import (
 
 func main() {
 	pulumi.Run(func(ctx *pulumi.Context) error {
-		vpcId, privateSubnetIds, publicSubnetIds, err := vpc.CreateInfrastructure(ctx)
+		cfg, err := stackconfig.Load(ctx)
 		if err != nil {
 			return err
 		}
 
-		eksCluster, err := eks.CreateInfrastructure(ctx, vpcId, privateSubnetIds, publicSubnetIds)
+		network, err := vpc.CreateInfrastructure(ctx, cfg)
 		if err != nil {
 			return err
 		}
 
-		err = traefik.CreateTraefikIngress(ctx, eksCluster)
+		eksCluster, err := eks.CreateInfrastructure(ctx, cfg, network)
 		if err != nil {
 			return err
 		}
 
-		err = certmanager.CreateCertManager(ctx, eksCluster)
+		err = traefik.CreateTraefikIngress(ctx, cfg.Namer, eksCluster)
+		if err != nil {
+			return err
+		}
+
+		err = certmanager.CreateCertManager(ctx, cfg.Namer, eksCluster)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,35 @@
+package naming
+
+import (
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Namer derives resource names from a project prefix and the stack name, so that every stack
+// gets its own names, e.g. u-staging-vpc and u-production-vpc
+type Namer struct {
+	prefix string
+}
+
+func New(projectPrefix string, stack string) *Namer {
+	return &Namer{prefix: projectPrefix + "-" + stack}
+}
+
+// Name joins parts onto the prefix, e.g. Name("rt", "private") is u-staging-rt-private
+func (n *Namer) Name(parts ...string) string {
+	return strings.Join(append([]string{n.prefix}, parts...), "-")
+}
+
+// Aliases lets a resource keep the state it was created with under a legacy name, so giving it
+// a derived name doesn't replace it. Empty legacy names are skipped.
+func Aliases(legacyNames ...string) pulumi.ResourceOption {
+	aliases := []pulumi.Alias{}
+	for _, legacyName := range legacyNames {
+		if legacyName != "" {
+			aliases = append(aliases, pulumi.Alias{Name: pulumi.String(legacyName)})
+		}
+	}
+
+	return pulumi.Aliases(aliases)
+}
@@ -1,13 +1,14 @@
 package nginx
 
 import (
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
 	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apps/v1"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
 	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func CreateNginx(ctx *pulumi.Context) error {
+func CreateNginx(ctx *pulumi.Context, provider *kubernetes.Provider) error {
 	// Create Nginx Deployment
 	_, err := appsv1.NewDeployment(ctx, "nginx-deployment", &appsv1.DeploymentArgs{
 		Kind:       pulumi.String("Deployment"),
@@ -43,7 +44,7 @@ func CreateNginx(ctx *pulumi.Context) error {
 				},
 			},
 		},
-	})
+	}, pulumi.Provider(provider))
 
 	// Create Nginx Service
 	_, err = corev1.NewService(ctx, "nginx-svc", &corev1.ServiceArgs{
@@ -68,7 +69,7 @@ func CreateNginx(ctx *pulumi.Context) error {
 				"run": pulumi.String("my-nginx"),
 			},
 		},
-	})
+	}, pulumi.Provider(provider))
 
 	if err != nil {
 		return err
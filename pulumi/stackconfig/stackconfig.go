@@ -0,0 +1,729 @@
+package stackconfig
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"uptactics/naming"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// StackConfig holds every setting the program reads from the stack configuration.
+// It is loaded and validated once in main so that a bad value is reported before
+// any resource is registered.
+type StackConfig struct {
+	// Namer derives resource names from the namePrefix setting and the stack name
+	Namer   *naming.Namer
+	Network NetworkConfig
+	Cluster ClusterConfig
+}
+
+type NetworkConfig struct {
+	// ExistingVpc is set when the program deploys into a VPC managed elsewhere instead of creating one.
+	// Only FlowLogs and PrivateDns apply to an existing VPC, the other settings are left empty.
+	ExistingVpc     *ExistingVpcConfig
+	VpcCidr         string
+	NatMode         NatMode
+	NatInstanceType string
+	// NatInstanceAmi pins the image of the NAT instance, by default it gets the latest Amazon Linux 2
+	NatInstanceAmi string
+	// Ipv6 makes the VPC dual-stack with an Amazon-provided IPv6 block and a /64 per subnet
+	Ipv6    bool
+	Subnets []SubnetConfig
+	// SubnetLayout is set when the subnets were carved out of VpcCidr instead of listed explicitly
+	SubnetLayout *SubnetLayout
+	// Endpoints is nil when no VPC endpoints are wanted
+	Endpoints *EndpointsConfig
+	// FlowLogs is nil when VPC flow logs are disabled
+	FlowLogs *FlowLogsConfig
+	// NetworkAcls is nil when subnets keep the default NACL. Tiers missing from the map get the default rules.
+	NetworkAcls map[Tier][]NetworkAclRule
+	// RemoteNetwork is nil when the VPC isn't connected to another network
+	RemoteNetwork *RemoteNetworkConfig
+	// unreadable is set when existingVpc couldn't be parsed, which leaves no network to validate
+	unreadable bool
+	// PrivateDns is nil when the VPC only has the Amazon-provided DNS names
+	PrivateDns *PrivateDnsConfig
+}
+
+// PrivateDnsConfig gives the VPC an internal domain, e.g. staging.uptactics.internal, served by a
+// private hosted zone and handed to instances through the DHCP options
+type PrivateDnsConfig struct {
+	Domain string `json:"domain"`
+}
+
+// ExistingVpcConfig selects a VPC managed outside this program, e.g. by the Terraform in uptactics/.
+// Exactly one of Id and Tags is set.
+type ExistingVpcConfig struct {
+	Id   string            `json:"id"`
+	Tags map[string]string `json:"tags"`
+	// TierTag is the subnet tag holding the subnet's tier. Subnets without it are left out of the network.
+	TierTag string `json:"tierTag"`
+}
+
+type RemoteNetworkType string
+
+const (
+	RemoteNetworkPeering        RemoteNetworkType = "peering"
+	RemoteNetworkTransitGateway RemoteNetworkType = "transit-gateway"
+)
+
+// RemoteNetworkConfig connects the VPC to another network, e.g. the shared-services VPC, through a VPC
+// peering connection or a Transit Gateway attachment. Cidrs are routed there from the private and public subnets.
+type RemoteNetworkConfig struct {
+	Type  RemoteNetworkType `json:"type"`
+	Cidrs []string          `json:"cidrs"`
+	// PeerVpcId, PeerOwnerId and PeerRegion select the peer VPC. Owner and region default to this stack's.
+	PeerVpcId   string `json:"peerVpcId"`
+	PeerOwnerId string `json:"peerOwnerId"`
+	PeerRegion  string `json:"peerRegion"`
+	// TransitGatewayId is the gateway the VPC is attached to
+	TransitGatewayId string `json:"transitGatewayId"`
+}
+
+// EndpointsConfig lists the AWS services reached through VPC endpoints instead of the NAT
+type EndpointsConfig struct {
+	S3Gateway bool `json:"s3Gateway"`
+	// Services are the interface endpoint service names, e.g. ecr.api
+	Services []string `json:"services"`
+}
+
+// NetworkAclRule is a single ingress or egress rule of a tier's network ACL.
+// Exactly one of CidrBlock and Ipv6CidrBlock is set.
+type NetworkAclRule struct {
+	RuleNo        int    `json:"ruleNo"`
+	Egress        bool   `json:"egress"`
+	Protocol      string `json:"protocol"`
+	Action        string `json:"action"`
+	CidrBlock     string `json:"cidrBlock"`
+	Ipv6CidrBlock string `json:"ipv6CidrBlock"`
+	FromPort      int    `json:"fromPort"`
+	ToPort        int    `json:"toPort"`
+}
+
+type FlowLogDestination string
+
+const (
+	FlowLogDestinationCloudWatch FlowLogDestination = "cloud-watch-logs"
+	FlowLogDestinationS3         FlowLogDestination = "s3"
+)
+
+// FlowLogsConfig configures the VPC flow logs. RetentionDays is the log group retention for
+// CloudWatch Logs and the object expiration for S3.
+type FlowLogsConfig struct {
+	Destination   FlowLogDestination `json:"destination"`
+	RetentionDays int                `json:"retentionDays"`
+	TrafficType   string             `json:"trafficType"`
+}
+
+// Services Fargate pods need to pull images, assume roles and ship logs
+var defaultEndpointServices = []string{"ecr.api", "ecr.dkr", "sts", "logs"}
+
+// Tier decides how a subnet is routed: public subnets go through the IGW, private
+// subnets through the NAT gateway and isolated subnets have no route out of the VPC
+type Tier string
+
+const (
+	TierPublic   Tier = "public"
+	TierPrivate  Tier = "private"
+	TierIsolated Tier = "isolated"
+)
+
+var tiers = []Tier{TierPublic, TierPrivate, TierIsolated}
+
+// NatMode decides how private subnets reach the internet
+type NatMode string
+
+const (
+	// NatModeSingle shares one NAT gateway between every AZ
+	NatModeSingle NatMode = "single"
+	// NatModePerAZ gives every AZ its own NAT gateway and private route table
+	NatModePerAZ NatMode = "per-az"
+	// NatModeInstance routes every AZ through a single NAT instance, which is cheaper for low traffic
+	NatModeInstance NatMode = "instance"
+	// NatModeNone leaves private subnets without a route to the internet, over IPv4 or IPv6
+	NatModeNone NatMode = "none"
+)
+
+var natModes = []NatMode{NatModeSingle, NatModePerAZ, NatModeInstance, NatModeNone}
+
+// SubnetConfig is a single entry of the structured `subnets` list
+type SubnetConfig struct {
+	// Name overrides the name derived from the tier and AZ, for subnets that already have one
+	Name string `json:"name"`
+	// LegacyName is the name the subnet was created with before names were derived from the stack.
+	// The subnet is aliased to it so the rename doesn't replace it.
+	LegacyName          string            `json:"legacyName"`
+	Cidr                string            `json:"cidr"`
+	AZ                  string            `json:"az"`
+	Tier                Tier              `json:"tier"`
+	Tags                map[string]string `json:"tags"`
+	MapPublicIpOnLaunch bool              `json:"mapPublicIpOnLaunch"`
+}
+
+// Every tier gets 64 of the 256 /64s in the VPC's IPv6 block, one per AZ
+const ipv6SubnetsPerTier = 64
+
+// Ipv6SubnetIndex returns the number of the /64 a subnet takes from the VPC's IPv6 block. It only depends on
+// the subnet's tier and the position of its AZ, so adding subnets doesn't renumber the others.
+func (n *NetworkConfig) Ipv6SubnetIndex(subnet SubnetConfig) int {
+	tierIndex := 0
+	for i, tier := range tiers {
+		if tier == subnet.Tier {
+			tierIndex = i
+		}
+	}
+
+	azIndex := 0
+	for i, az := range n.azOrder() {
+		if az == subnet.AZ {
+			azIndex = i
+		}
+	}
+
+	return tierIndex*ipv6SubnetsPerTier + azIndex
+}
+
+// azOrder lists the AZs in the order of the subnet layout, or in the order they first appear in the subnets
+func (n *NetworkConfig) azOrder() []string {
+	if n.SubnetLayout != nil {
+		return n.SubnetLayout.AZs
+	}
+
+	azs := []string{}
+	seen := map[string]bool{}
+	for _, subnet := range n.Subnets {
+		if !seen[subnet.AZ] {
+			azs = append(azs, subnet.AZ)
+			seen[subnet.AZ] = true
+		}
+	}
+
+	return azs
+}
+
+// SubnetLayout describes subnets by tier and AZ and lets the program pick their CIDRs. Every tier gets a
+// block with room for MaxAZs subnets, so AZs can be appended up to MaxAZs without moving other subnets.
+type SubnetLayout struct {
+	AZs []string `json:"azs"`
+	// MaxAZs can't change once subnets exist, it defaults to 4
+	MaxAZs int                `json:"maxAzs"`
+	Tiers  []SubnetTierLayout `json:"tiers"`
+}
+
+// SubnetTierLayout asks for one subnet of the given prefix length in every AZ of the layout
+type SubnetTierLayout struct {
+	Tier                Tier              `json:"tier"`
+	PrefixLength        int               `json:"prefixLength"`
+	Tags                map[string]string `json:"tags"`
+	MapPublicIpOnLaunch bool              `json:"mapPublicIpOnLaunch"`
+}
+
+type ClusterConfig struct {
+	// Name is derived from the stack
+	Name           string
+	Version        string
+	EndpointAccess EndpointAccessConfig
+	// SecretsKmsKeyArn is an existing key to encrypt Secrets with. Without it the program creates one.
+	SecretsKmsKeyArn string
+	Kubeconfig       KubeconfigConfig
+	// Logging is nil when the control plane doesn't log to CloudWatch Logs
+	Logging *ClusterLoggingConfig
+	// FargateProfiles decide which pods run on Fargate
+	FargateProfiles []FargateProfileConfig
+	// NodeGroups are the managed EC2 node groups running next to the Fargate profiles
+	NodeGroups []NodeGroupConfig
+	// Addons are the EKS add-ons managed by the program
+	Addons []AddonConfig
+	// Access maps IAM principals to Kubernetes users and groups in the aws-auth ConfigMap
+	Access ClusterAccessConfig
+}
+
+const (
+	// AddonVersionDefault picks the version EKS installs by default for the cluster version
+	AddonVersionDefault = ""
+	// AddonVersionLatest picks the newest version compatible with the cluster version
+	AddonVersionLatest = "latest"
+)
+
+type ResolveConflicts string
+
+const (
+	ResolveConflictsNone      ResolveConflicts = "none"
+	ResolveConflictsOverwrite ResolveConflicts = "overwrite"
+)
+
+// AddonConfig is a single entry of the `addons` list
+type AddonConfig struct {
+	// Name is the EKS add-on name, e.g. vpc-cni, coredns, kube-proxy or aws-ebs-csi-driver
+	Name string `json:"name"`
+	// Version is an add-on version like v1.11.4-eksbuild.1, AddonVersionLatest or AddonVersionDefault
+	Version          string           `json:"version"`
+	ResolveConflicts ResolveConflicts `json:"resolveConflicts"`
+	// ConfigurationValues are passed to EKS as JSON, they have to match the schema of the add-on version
+	ConfigurationValues map[string]interface{} `json:"configurationValues"`
+	// ServiceAccountRoleArn is an existing IRSA role for the add-on's service account
+	ServiceAccountRoleArn string `json:"serviceAccountRoleArn"`
+	// ServiceAccount is set to have an IRSA role created for the add-on instead
+	ServiceAccount *AddonServiceAccount `json:"serviceAccount"`
+}
+
+// AddonServiceAccount is the service account an add-on runs as, and the managed policies its IRSA role gets.
+// Namespace defaults to kube-system.
+type AddonServiceAccount struct {
+	Namespace  string   `json:"namespace"`
+	Name       string   `json:"name"`
+	PolicyArns []string `json:"policyArns"`
+}
+
+// EndpointAccessConfig decides where the cluster API can be reached from. The private endpoint accepts
+// PrivateAccessCidrs, which default to the VPC CIDR, the public endpoint PublicAccessCidrs.
+type EndpointAccessConfig struct {
+	PrivateAccess      bool     `json:"privateAccess"`
+	PrivateAccessCidrs []string `json:"privateAccessCidrs"`
+	PublicAccess       bool     `json:"publicAccess"`
+	PublicAccessCidrs  []string `json:"publicAccessCidrs"`
+}
+
+// ClusterLoggingConfig lists the control plane logs sent to the cluster's log group, which keeps them for
+// RetentionDays and is encrypted with KmsKeyArn when set
+type ClusterLoggingConfig struct {
+	Types         []string `json:"types"`
+	RetentionDays int      `json:"retentionDays"`
+	KmsKeyArn     string   `json:"kmsKeyArn"`
+}
+
+// Control plane log types EKS can send to CloudWatch Logs
+var clusterLogTypes = []string{"api", "audit", "authenticator", "controllerManager", "scheduler"}
+
+// KubeconfigConfig is how the exported kubeconfig authenticates. RoleArn is a role `aws eks get-token`
+// assumes, Profile the AWS profile it runs with, which defaults to aws:profile.
+type KubeconfigConfig struct {
+	RoleArn string `json:"roleArn"`
+	Profile string `json:"profile"`
+}
+
+// ClusterAccessConfig lists the IAM roles and users that may use the cluster, next to the identity that created it
+type ClusterAccessConfig struct {
+	Roles []IamIdentityMapping `json:"roles"`
+	Users []IamIdentityMapping `json:"users"`
+}
+
+// IamIdentityMapping maps an IAM role or user to a Kubernetes user and its groups. Username defaults to the
+// name in the ARN, for roles followed by :{{SessionName}} so the audit log shows who assumed the role.
+type IamIdentityMapping struct {
+	Arn      string   `json:"arn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// FargateProfileConfig is a single entry of the `fargateProfiles` list. Pods matching one of the Selectors run
+// on Fargate in the subnets of Tier in AZs, which default to private and every AZ of the tier.
+type FargateProfileConfig struct {
+	Name string `json:"name"`
+	// LegacyName is the name the profile was created with before names were derived from the stack. A profile
+	// can't be renamed without replacing it, so it keeps the legacy name.
+	LegacyName string            `json:"legacyName"`
+	Selectors  []FargateSelector `json:"selectors"`
+	Tier       Tier              `json:"tier"`
+	AZs        []string          `json:"azs"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// FargateSelector selects the pods of a namespace that carry all of Labels. Namespace and label values
+// may contain the * and ? wildcards.
+type FargateSelector struct {
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// Selects tells whether a pod in namespace with labels matches the selector
+func (s FargateSelector) Selects(namespace string, labels map[string]string) bool {
+	if matched, _ := path.Match(s.Namespace, namespace); !matched {
+		return false
+	}
+	for key, value := range s.Labels {
+		podValue, ok := labels[key]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(value, podValue); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The labels of the CoreDNS pods EKS deploys
+var coreDNSLabels = map[string]string{"k8s-app": "kube-dns", "eks.amazonaws.com/component": "coredns"}
+
+// CoreDNSFargateProfile returns the name of the profile that selects the CoreDNS pods, or "" if they
+// don't run on Fargate
+func (c *ClusterConfig) CoreDNSFargateProfile() string {
+	for _, profile := range c.FargateProfiles {
+		for _, selector := range profile.Selectors {
+			if selector.Selects("kube-system", coreDNSLabels) {
+				return profile.Name
+			}
+		}
+	}
+
+	return ""
+}
+
+type CapacityType string
+
+const (
+	CapacityTypeOnDemand CapacityType = "on-demand"
+	CapacityTypeSpot     CapacityType = "spot"
+)
+
+// NodeGroupConfig is a single entry of the `nodeGroups` list. The group is placed in the subnets
+// of Tier in AZs, which default to private and every AZ of the tier.
+type NodeGroupConfig struct {
+	Name          string       `json:"name"`
+	InstanceTypes []string     `json:"instanceTypes"`
+	CapacityType  CapacityType `json:"capacityType"`
+	MinSize       int          `json:"minSize"`
+	MaxSize       int          `json:"maxSize"`
+	// DesiredSize is only the initial size, afterwards it is left to the cluster autoscaler. Defaults to MinSize.
+	DesiredSize *int              `json:"desiredSize"`
+	DiskSize    int               `json:"diskSize"`
+	Labels      map[string]string `json:"labels"`
+	Taints      []NodeGroupTaint  `json:"taints"`
+	Tier        Tier              `json:"tier"`
+	AZs         []string          `json:"azs"`
+}
+
+// NodeGroupTaint is a Kubernetes taint, with Effect one of NoSchedule, NoExecute or PreferNoSchedule
+type NodeGroupTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Effect string `json:"effect"`
+}
+
+// TaintEffects maps the Kubernetes taint effects to the names the EKS API uses for them
+var TaintEffects = map[string]string{
+	"NoSchedule":       "NO_SCHEDULE",
+	"NoExecute":        "NO_EXECUTE",
+	"PreferNoSchedule": "PREFER_NO_SCHEDULE",
+}
+
+// The values of the settings for a created VPC that are harmless together with existingVpc
+var existingVpcNoops = map[string]string{
+	"natMode": string(NatModeNone),
+	"ipv6":    "false",
+}
+
+// Load reads the stack configuration and validates it, returning every problem found at once
+func Load(ctx *pulumi.Context) (*StackConfig, error) {
+	conf := config.New(ctx, "")
+	errs := &validationErrors{}
+
+	require := func(key string) string {
+		value := conf.Get(key)
+		if value == "" {
+			errs.add("%s is required", key)
+		}
+		return value
+	}
+
+	namePrefix := conf.Get("namePrefix")
+	if namePrefix == "" {
+		namePrefix = ctx.Project()
+	}
+	namer := naming.New(namePrefix, ctx.Stack())
+
+	cfg := &StackConfig{
+		Namer: namer,
+		Cluster: ClusterConfig{
+			Name:    namer.Name("k8s", "cluster"),
+			Version: require("clusterVersion"),
+			// The public endpoint has to be asked for, together with the CIDRs it is open to
+			EndpointAccess: EndpointAccessConfig{
+				PrivateAccess: true,
+			},
+			SecretsKmsKeyArn: conf.Get("secretsKmsKeyArn"),
+			Kubeconfig: KubeconfigConfig{
+				Profile: config.New(ctx, "aws").Get("profile"),
+			},
+		},
+	}
+
+	if conf.Get("existingVpc") != "" {
+		existingVpc := &ExistingVpcConfig{TierTag: "Tier"}
+		if err := conf.GetObject("existingVpc", existingVpc); err != nil {
+			errs.add("existingVpc: %s", err)
+			cfg.Network.unreadable = true
+		} else {
+			cfg.Network.ExistingVpc = existingVpc
+		}
+
+		// These shape a VPC this program creates, the existing one is shaped by whoever manages it.
+		// Values asking for nothing to be created are accepted.
+		for _, key := range []string{"vpcCidr", "subnets", "subnetLayout", "natMode", "natInstanceType", "natInstanceAmi", "ipv6", "vpcEndpoints", "networkAcls", "remoteNetwork"} {
+			if value := conf.Get(key); value != "" && value != existingVpcNoops[key] {
+				errs.add("%s cannot be set together with existingVpc", key)
+			}
+		}
+	} else {
+		cfg.Network.load(conf, namer, errs)
+	}
+
+	if conf.Get("flowLogs") != "" {
+		flowLogs := &FlowLogsConfig{
+			Destination:   FlowLogDestinationCloudWatch,
+			RetentionDays: 30,
+			TrafficType:   "ALL",
+		}
+		if err := conf.GetObject("flowLogs", flowLogs); err != nil {
+			errs.add("flowLogs: %s", err)
+		} else {
+			cfg.Network.FlowLogs = flowLogs
+		}
+	}
+
+	if conf.Get("privateDns") != "" {
+		privateDns := &PrivateDnsConfig{}
+		if err := conf.GetObject("privateDns", privateDns); err != nil {
+			errs.add("privateDns: %s", err)
+		} else {
+			cfg.Network.PrivateDns = privateDns
+		}
+	}
+
+	if conf.Get("clusterEndpointAccess") != "" {
+		if err := conf.GetObject("clusterEndpointAccess", &cfg.Cluster.EndpointAccess); err != nil {
+			errs.add("clusterEndpointAccess: %s", err)
+		}
+	}
+
+	if conf.Get("kubeconfig") != "" {
+		if err := conf.GetObject("kubeconfig", &cfg.Cluster.Kubeconfig); err != nil {
+			errs.add("kubeconfig: %s", err)
+		}
+	}
+
+	if conf.Get("clusterLogging") != "" {
+		logging := &ClusterLoggingConfig{
+			Types:         []string{"api", "audit", "authenticator"},
+			RetentionDays: 90,
+		}
+		if err := conf.GetObject("clusterLogging", logging); err != nil {
+			errs.add("clusterLogging: %s", err)
+		} else {
+			cfg.Cluster.Logging = logging
+		}
+	}
+
+	// Without the setting the cluster gets a profile for the system namespaces and one for the applications,
+	// under the names they had before profiles were configurable
+	if conf.Get("fargateProfiles") == "" {
+		cfg.Cluster.FargateProfiles = []FargateProfileConfig{
+			{
+				Name:       "system",
+				LegacyName: cfg.Namer.Name("k8s", "fargate"),
+				Selectors:  []FargateSelector{{Namespace: "kube-system"}, {Namespace: "default"}},
+			},
+			{
+				Name:       "apps",
+				LegacyName: cfg.Namer.Name("k8s", "fargate", "apps"),
+				Selectors:  []FargateSelector{{Namespace: "traefik"}, {Namespace: "cert-manager"}, {Namespace: "apps-*"}},
+			},
+		}
+	} else if err := conf.GetObject("fargateProfiles", &cfg.Cluster.FargateProfiles); err != nil {
+		errs.add("fargateProfiles: %s", err)
+	}
+	for i := range cfg.Cluster.FargateProfiles {
+		profile := &cfg.Cluster.FargateProfiles[i]
+		if profile.Tier == "" {
+			profile.Tier = TierPrivate
+		}
+	}
+
+	if err := conf.GetObject("nodeGroups", &cfg.Cluster.NodeGroups); err != nil {
+		errs.add("nodeGroups: %s", err)
+	}
+	for i := range cfg.Cluster.NodeGroups {
+		nodeGroup := &cfg.Cluster.NodeGroups[i]
+		if len(nodeGroup.InstanceTypes) == 0 {
+			nodeGroup.InstanceTypes = []string{"t3.medium"}
+		}
+		if nodeGroup.CapacityType == "" {
+			nodeGroup.CapacityType = CapacityTypeOnDemand
+		}
+		if nodeGroup.DesiredSize == nil {
+			nodeGroup.DesiredSize = &nodeGroup.MinSize
+		}
+		if nodeGroup.DiskSize == 0 {
+			nodeGroup.DiskSize = 20
+		}
+		if nodeGroup.Tier == "" {
+			nodeGroup.Tier = TierPrivate
+		}
+	}
+
+	if err := conf.GetObject("addons", &cfg.Cluster.Addons); err != nil {
+		errs.add("addons: %s", err)
+	}
+	for i := range cfg.Cluster.Addons {
+		addon := &cfg.Cluster.Addons[i]
+		if addon.ResolveConflicts == "" {
+			addon.ResolveConflicts = ResolveConflictsOverwrite
+		}
+		if addon.ServiceAccount != nil && addon.ServiceAccount.Namespace == "" {
+			addon.ServiceAccount.Namespace = "kube-system"
+		}
+	}
+
+	if err := conf.GetObject("clusterAccess", &cfg.Cluster.Access); err != nil {
+		errs.add("clusterAccess: %s", err)
+	}
+	for i := range cfg.Cluster.Access.Roles {
+		role := &cfg.Cluster.Access.Roles[i]
+		if role.Username == "" {
+			role.Username = role.Arn[strings.LastIndex(role.Arn, "/")+1:] + ":{{SessionName}}"
+		}
+	}
+	for i := range cfg.Cluster.Access.Users {
+		user := &cfg.Cluster.Access.Users[i]
+		if user.Username == "" {
+			user.Username = user.Arn[strings.LastIndex(user.Arn, "/")+1:]
+		}
+	}
+
+	cfg.validate(errs)
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// load reads the settings of a VPC created by this program
+func (n *NetworkConfig) load(conf *config.Config, namer *naming.Namer, errs *validationErrors) {
+	n.VpcCidr = conf.Get("vpcCidr")
+	if n.VpcCidr == "" {
+		errs.add("vpcCidr is required")
+	}
+	n.NatMode = NatMode(conf.Get("natMode"))
+	n.Ipv6 = conf.GetBool("ipv6")
+
+	if err := conf.GetObject("subnets", &n.Subnets); err != nil {
+		errs.add("subnets: %s", err)
+	}
+
+	if conf.Get("subnetLayout") != "" {
+		layout := &SubnetLayout{}
+		if err := conf.GetObject("subnetLayout", layout); err != nil {
+			errs.add("subnetLayout: %s", err)
+		} else if len(n.Subnets) > 0 {
+			errs.add("subnets and subnetLayout cannot both be set")
+		} else {
+			if layout.MaxAZs == 0 {
+				layout.MaxAZs = 4
+			}
+			n.SubnetLayout = layout
+			n.Subnets = n.carveSubnets(errs)
+		}
+	}
+
+	if len(n.Subnets) == 0 && n.SubnetLayout == nil {
+		errs.add("one of subnets or subnetLayout is required")
+	}
+
+	for i := range n.Subnets {
+		subnet := &n.Subnets[i]
+		if subnet.Name == "" {
+			subnet.Name = namer.Name(string(subnet.Tier), subnet.AZ)
+		}
+	}
+
+	if n.NatMode == "" {
+		n.NatMode = NatModeSingle
+	}
+	if n.NatMode == NatModeInstance {
+		n.NatInstanceType = conf.Get("natInstanceType")
+		if n.NatInstanceType == "" {
+			n.NatInstanceType = "t3.nano"
+		}
+		n.NatInstanceAmi = conf.Get("natInstanceAmi")
+	}
+
+	if conf.Get("vpcEndpoints") != "" {
+		endpoints := &EndpointsConfig{}
+		if err := conf.GetObject("vpcEndpoints", endpoints); err != nil {
+			errs.add("vpcEndpoints: %s", err)
+		} else {
+			if endpoints.Services == nil {
+				endpoints.Services = defaultEndpointServices
+			}
+			n.Endpoints = endpoints
+		}
+	}
+
+	if conf.Get("networkAcls") != "" {
+		networkAcls := map[Tier][]NetworkAclRule{}
+		if err := conf.GetObject("networkAcls", &networkAcls); err != nil {
+			errs.add("networkAcls: %s", err)
+		} else {
+			n.NetworkAcls = networkAcls
+		}
+	}
+
+	if conf.Get("remoteNetwork") != "" {
+		remoteNetwork := &RemoteNetworkConfig{}
+		if err := conf.GetObject("remoteNetwork", remoteNetwork); err != nil {
+			errs.add("remoteNetwork: %s", err)
+		} else {
+			n.RemoteNetwork = remoteNetwork
+		}
+	}
+}
+
+// AZs returns the availability zones that have a subnet in the given tier, in configuration order
+func (n *NetworkConfig) AZs(tier Tier) []string {
+	azs := []string{}
+	seen := map[string]bool{}
+	for _, subnet := range n.Subnets {
+		if subnet.Tier == tier && !seen[subnet.AZ] {
+			azs = append(azs, subnet.AZ)
+			seen[subnet.AZ] = true
+		}
+	}
+
+	return azs
+}
+
+// HasTier reports whether at least one subnet is configured in the given tier
+func (n *NetworkConfig) HasTier(tier Tier) bool {
+	for _, subnet := range n.Subnets {
+		if subnet.Tier == tier {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validationErrors collects every configuration problem so they can be reported together
+type validationErrors struct {
+	messages []string
+}
+
+func (e *validationErrors) add(format string, args ...interface{}) {
+	e.messages = append(e.messages, fmt.Sprintf(format, args...))
+}
+
+func (e *validationErrors) err() error {
+	if len(e.messages) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("invalid stack configuration:\n  - %s", strings.Join(e.messages, "\n  - "))
+}
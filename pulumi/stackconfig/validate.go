@@ -0,0 +1,746 @@
+package stackconfig
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"uptactics/cidr"
+)
+
+// Retention periods CloudWatch Logs accepts for a log group
+var logRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1827, 3653}
+
+// A lowercase DNS label, e.g. uptactics
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// IAM role and user ARNs, which may have a path, e.g. arn:aws:iam::123456789012:role/admin
+var (
+	iamRoleArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/[\w+=,.@/-]+$`)
+	iamUserArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:user/[\w+=,.@/-]+$`)
+)
+
+// Kubernetes groups the node and Fargate pod roles are mapped to. A person in them could act as a node.
+var nodeRoleGroups = []string{"system:bootstrappers", "system:nodes", "system:node-proxier"}
+
+// EKS versions this program has been deployed and tested with
+var supportedClusterVersions = []string{"1.20", "1.21", "1.22", "1.23"}
+
+func (c *StackConfig) validate(errs *validationErrors) {
+	c.Network.validate(errs)
+	c.Cluster.validate(errs)
+
+	// The subnets of an existing VPC are only known once they are read
+	if c.Network.ExistingVpc == nil && !c.Network.unreadable {
+		for _, nodeGroup := range c.Cluster.NodeGroups {
+			c.Network.validatePlacement(errs, fmt.Sprintf("nodeGroup %q", nodeGroup.Name), nodeGroup.Tier, nodeGroup.AZs)
+		}
+		for _, profile := range c.Cluster.FargateProfiles {
+			c.Network.validatePlacement(errs, fmt.Sprintf("fargateProfile %q", profile.Name), profile.Tier, profile.AZs)
+		}
+	}
+}
+
+// validatePlacement checks that there are subnets of tier in azs, or any subnets of tier if azs is empty
+func (n *NetworkConfig) validatePlacement(errs *validationErrors, what string, tier Tier, azs []string) {
+	tierAZs := map[string]bool{}
+	for _, az := range n.AZs(tier) {
+		tierAZs[az] = true
+	}
+
+	if len(tierAZs) == 0 {
+		errs.add("%s: there are no %s subnets", what, tier)
+	}
+	for _, az := range azs {
+		if !tierAZs[az] {
+			errs.add("%s: there is no %s subnet in %s", what, tier, az)
+		}
+	}
+}
+
+func (n *NetworkConfig) validate(errs *validationErrors) {
+	// An unparseable existingVpc is reported by Load, only the settings that don't depend on it are left
+	if n.ExistingVpc != nil || n.unreadable {
+		if n.ExistingVpc != nil {
+			n.validateExistingVpc(errs)
+		}
+		n.validateFlowLogs(errs)
+		n.validatePrivateDns(errs)
+		return
+	}
+
+	n.validateNat(errs)
+	n.validateEndpoints(errs)
+	n.validateFlowLogs(errs)
+	n.validateNetworkAcls(errs)
+	n.validateRemoteNetwork(errs)
+	n.validatePrivateDns(errs)
+
+	// The Amazon-provided IPv6 block is a /56, which holds 256 subnet /64s
+	if n.Ipv6 && len(n.azOrder()) > ipv6SubnetsPerTier {
+		errs.add("ipv6 supports at most %d AZs, got %d", ipv6SubnetsPerTier, len(n.azOrder()))
+	}
+
+	if n.VpcCidr == "" {
+		return
+	}
+
+	vpcNet, err := cidr.Parse(n.VpcCidr)
+	if err != nil {
+		errs.add("vpcCidr: %s", err)
+		return
+	}
+
+	seenTierAZs := map[string]bool{}
+	seenNames := map[string]bool{}
+	subnetNets := make([]*net.IPNet, len(n.Subnets))
+
+	for i, subnet := range n.Subnets {
+		if subnet.AZ == "" {
+			errs.add("subnet #%d has no availability zone", i+1)
+		}
+
+		if seenNames[subnet.Name] {
+			errs.add("subnet name %q is used more than once", subnet.Name)
+		}
+		seenNames[subnet.Name] = true
+
+		if !subnet.Tier.valid() {
+			errs.add("subnet %q has tier %q, expected one of %v", subnet.Name, subnet.Tier, tiers)
+		}
+
+		// The network hands out subnets per tier and AZ
+		tierAZ := string(subnet.Tier) + "/" + subnet.AZ
+		if seenTierAZs[tierAZ] {
+			errs.add("subnet %q is defined more than once, only one subnet per tier and AZ is supported", subnet.Name)
+		}
+		seenTierAZs[tierAZ] = true
+
+		subnetNet, err := cidr.Parse(subnet.Cidr)
+		if err != nil {
+			errs.add("subnet %q: %s", subnet.Name, err)
+			continue
+		}
+
+		if !cidr.Contains(vpcNet, subnetNet) {
+			errs.add("subnet %q cidr %s is not inside vpcCidr %s", subnet.Name, subnet.Cidr, n.VpcCidr)
+		}
+
+		for j := 0; j < i; j++ {
+			if subnetNets[j] != nil && cidr.Overlaps(subnetNets[j], subnetNet) {
+				errs.add("subnet %q cidr %s overlaps subnet %q cidr %s",
+					subnet.Name, subnet.Cidr, n.Subnets[j].Name, n.Subnets[j].Cidr)
+			}
+		}
+		subnetNets[i] = subnetNet
+	}
+}
+
+func (n *NetworkConfig) validateExistingVpc(errs *validationErrors) {
+	if (n.ExistingVpc.Id == "") == (len(n.ExistingVpc.Tags) == 0) {
+		errs.add("existingVpc: exactly one of id and tags must be set")
+	}
+	if n.ExistingVpc.TierTag == "" {
+		errs.add("existingVpc.tierTag cannot be empty")
+	}
+}
+
+func (n *NetworkConfig) validateRemoteNetwork(errs *validationErrors) {
+	remote := n.RemoteNetwork
+	if remote == nil {
+		return
+	}
+
+	switch remote.Type {
+	case RemoteNetworkPeering:
+		if remote.PeerVpcId == "" {
+			errs.add("remoteNetwork.peerVpcId is required for %s", remote.Type)
+		}
+		if remote.TransitGatewayId != "" {
+			errs.add("remoteNetwork.transitGatewayId cannot be set for %s", remote.Type)
+		}
+	case RemoteNetworkTransitGateway:
+		if remote.TransitGatewayId == "" {
+			errs.add("remoteNetwork.transitGatewayId is required for %s", remote.Type)
+		}
+		if remote.PeerVpcId != "" || remote.PeerOwnerId != "" || remote.PeerRegion != "" {
+			errs.add("remoteNetwork.peerVpcId, peerOwnerId and peerRegion cannot be set for %s", remote.Type)
+		}
+		// The attachment gets a network interface in one private subnet per AZ
+		if len(n.Subnets) > 0 && !n.HasTier(TierPrivate) {
+			errs.add("remoteNetwork type %s needs at least one private subnet", remote.Type)
+		}
+	default:
+		errs.add("remoteNetwork.type %q is not one of %s or %s", remote.Type, RemoteNetworkPeering, RemoteNetworkTransitGateway)
+	}
+
+	if len(remote.Cidrs) == 0 {
+		errs.add("remoteNetwork.cidrs is required")
+	}
+
+	// Traffic to an overlapping CIDR would be ambiguous, so the remote CIDRs can't overlap the VPC
+	// or each other. An invalid vpcCidr is reported by validate.
+	vpcNet, _ := cidr.Parse(n.VpcCidr)
+	remoteNets := make([]*net.IPNet, len(remote.Cidrs))
+
+	for i, remoteCidr := range remote.Cidrs {
+		ip, remoteNet, err := net.ParseCIDR(remoteCidr)
+		if err != nil || ip.To4() == nil || !ip.Equal(remoteNet.IP) {
+			errs.add("remoteNetwork.cidrs: %q is not an IPv4 CIDR block without host bits", remoteCidr)
+			continue
+		}
+
+		if vpcNet != nil && cidr.Overlaps(vpcNet, remoteNet) {
+			errs.add("remoteNetwork cidr %s overlaps vpcCidr %s", remoteCidr, n.VpcCidr)
+		}
+
+		for j := 0; j < i; j++ {
+			if remoteNets[j] != nil && cidr.Overlaps(remoteNets[j], remoteNet) {
+				errs.add("remoteNetwork cidr %s overlaps remoteNetwork cidr %s", remoteCidr, remote.Cidrs[j])
+			}
+		}
+		remoteNets[i] = remoteNet
+	}
+}
+
+func (n *NetworkConfig) validatePrivateDns(errs *validationErrors) {
+	if n.PrivateDns == nil {
+		return
+	}
+
+	domain := n.PrivateDns.Domain
+	if domain == "" {
+		errs.add("privateDns.domain is required")
+		return
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(domain) > 253 || len(labels) < 2 {
+		errs.add("privateDns.domain %q is not a domain name with at least two labels", domain)
+		return
+	}
+	for _, label := range labels {
+		if !dnsLabel.MatchString(label) {
+			errs.add("privateDns.domain %q has invalid label %q", domain, label)
+		}
+	}
+}
+
+func (n *NetworkConfig) validateEndpoints(errs *validationErrors) {
+	if n.Endpoints == nil {
+		return
+	}
+
+	if len(n.Endpoints.Services) > 0 && len(n.Subnets) > 0 && !n.HasTier(TierPrivate) {
+		errs.add("vpcEndpoints.services needs at least one private subnet")
+	}
+
+	seen := map[string]bool{}
+	for _, service := range n.Endpoints.Services {
+		if service == "" {
+			errs.add("vpcEndpoints.services contains an empty service name")
+		} else if seen[service] {
+			errs.add("vpcEndpoints.services lists %q more than once", service)
+		} else if service == "s3" {
+			errs.add("vpcEndpoints.services cannot contain s3, use vpcEndpoints.s3Gateway instead")
+		}
+		seen[service] = true
+	}
+}
+
+func (n *NetworkConfig) validateFlowLogs(errs *validationErrors) {
+	if n.FlowLogs == nil {
+		return
+	}
+
+	switch n.FlowLogs.Destination {
+	case FlowLogDestinationCloudWatch:
+		if !validLogRetention(n.FlowLogs.RetentionDays) {
+			errs.add("flowLogs.retentionDays %d is not one of %v", n.FlowLogs.RetentionDays, logRetentionDays)
+		}
+	case FlowLogDestinationS3:
+		if n.FlowLogs.RetentionDays < 1 {
+			errs.add("flowLogs.retentionDays must be at least 1")
+		}
+	default:
+		errs.add("flowLogs.destination %q is not one of %s or %s",
+			n.FlowLogs.Destination, FlowLogDestinationCloudWatch, FlowLogDestinationS3)
+	}
+
+	switch n.FlowLogs.TrafficType {
+	case "ACCEPT", "REJECT", "ALL":
+	default:
+		errs.add("flowLogs.trafficType %q is not one of ACCEPT, REJECT or ALL", n.FlowLogs.TrafficType)
+	}
+}
+
+func (n *NetworkConfig) validateNetworkAcls(errs *validationErrors) {
+	for tier, rules := range n.NetworkAcls {
+		if !tier.valid() {
+			errs.add("networkAcls has rules for unknown tier %q", tier)
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, rule := range rules {
+			direction := "ingress"
+			if rule.Egress {
+				direction = "egress"
+			}
+			name := fmt.Sprintf("networkAcls.%s %s rule %d", tier, direction, rule.RuleNo)
+
+			if rule.RuleNo < 1 || rule.RuleNo > 32766 {
+				errs.add("%s: ruleNo must be between 1 and 32766", name)
+			}
+			if seen[direction+fmt.Sprint(rule.RuleNo)] {
+				errs.add("%s is defined more than once", name)
+			}
+			seen[direction+fmt.Sprint(rule.RuleNo)] = true
+
+			if rule.Action != "allow" && rule.Action != "deny" {
+				errs.add("%s: action %q is not allow or deny", name, rule.Action)
+			}
+
+			if rule.Protocol == "" {
+				errs.add("%s: protocol is required", name)
+			}
+
+			if rule.FromPort < 0 || rule.ToPort > 65535 || rule.FromPort > rule.ToPort {
+				errs.add("%s: port range %d-%d is invalid", name, rule.FromPort, rule.ToPort)
+			}
+
+			if (rule.CidrBlock == "") == (rule.Ipv6CidrBlock == "") {
+				errs.add("%s: exactly one of cidrBlock and ipv6CidrBlock must be set", name)
+			}
+			if rule.CidrBlock != "" {
+				if _, _, err := net.ParseCIDR(rule.CidrBlock); err != nil {
+					errs.add("%s: %s", name, err)
+				}
+			}
+			if rule.Ipv6CidrBlock != "" {
+				if _, _, err := net.ParseCIDR(rule.Ipv6CidrBlock); err != nil {
+					errs.add("%s: %s", name, err)
+				}
+			}
+		}
+	}
+}
+
+func validLogRetention(days int) bool {
+	for _, allowed := range logRetentionDays {
+		if days == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *NetworkConfig) validateNat(errs *validationErrors) {
+	switch n.NatMode {
+	case NatModeNone:
+	case NatModeSingle, NatModeInstance:
+		// The NAT gateway or instance is placed in a public subnet
+		if len(n.Subnets) > 0 && !n.HasTier(TierPublic) {
+			errs.add("natMode %s needs at least one public subnet", n.NatMode)
+		}
+	case NatModePerAZ:
+		publicAZs := map[string]bool{}
+		for _, az := range n.AZs(TierPublic) {
+			publicAZs[az] = true
+		}
+
+		for _, az := range n.AZs(TierPrivate) {
+			if !publicAZs[az] {
+				errs.add("natMode %s needs a public subnet in %s for its private subnets", n.NatMode, az)
+			}
+		}
+	default:
+		errs.add("natMode %q is not one of %v", n.NatMode, natModes)
+	}
+}
+
+func (t Tier) valid() bool {
+	for _, tier := range tiers {
+		if t == tier {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ClusterConfig) validate(errs *validationErrors) {
+	c.validateVersion(errs)
+	c.validateEndpointAccess(errs)
+
+	if c.SecretsKmsKeyArn != "" && !strings.HasPrefix(c.SecretsKmsKeyArn, "arn:") {
+		errs.add("secretsKmsKeyArn %q is not an ARN", c.SecretsKmsKeyArn)
+	}
+
+	c.validateLogging(errs)
+
+	if c.Kubeconfig.RoleArn != "" && !strings.HasPrefix(c.Kubeconfig.RoleArn, "arn:") {
+		errs.add("kubeconfig.roleArn %q is not an ARN", c.Kubeconfig.RoleArn)
+	}
+	c.validateFargateProfiles(errs)
+	c.validateNodeGroups(errs)
+	c.validateAddons(errs)
+
+	if len(c.FargateProfiles) == 0 && len(c.NodeGroups) == 0 {
+		errs.add("the cluster needs at least one Fargate profile or node group to run pods on")
+	} else if len(c.NodeGroups) == 0 && c.CoreDNSFargateProfile() == "" {
+		errs.add("CoreDNS needs a Fargate profile selecting its pods in kube-system, or a node group")
+	}
+	c.validateAccess(errs)
+}
+
+func (c *ClusterConfig) validateAccess(errs *validationErrors) {
+	seen := map[string]bool{}
+
+	validateMapping := func(kind string, mapping IamIdentityMapping, arnPattern *regexp.Regexp) {
+		if !arnPattern.MatchString(mapping.Arn) {
+			errs.add("clusterAccess.%s: %q is not an IAM %s ARN", kind, mapping.Arn, strings.TrimSuffix(kind, "s"))
+			return
+		}
+		if seen[mapping.Arn] {
+			errs.add("clusterAccess.%s: %q is mapped more than once", kind, mapping.Arn)
+		}
+		seen[mapping.Arn] = true
+
+		if strings.HasPrefix(mapping.Username, "system:") {
+			errs.add("clusterAccess.%s: %q has username %q, the system: prefix is reserved by Kubernetes", kind, mapping.Arn, mapping.Username)
+		}
+		for _, group := range mapping.Groups {
+			for _, nodeRoleGroup := range nodeRoleGroups {
+				if group == nodeRoleGroup {
+					errs.add("clusterAccess.%s: %q cannot be in group %s, it is reserved for the node and Fargate roles", kind, mapping.Arn, group)
+				}
+			}
+		}
+	}
+
+	for _, role := range c.Access.Roles {
+		validateMapping("roles", role, iamRoleArn)
+	}
+	for _, user := range c.Access.Users {
+		validateMapping("users", user, iamUserArn)
+	}
+}
+
+func (c *ClusterConfig) validateAddons(errs *validationErrors) {
+	seen := map[string]bool{}
+
+	for i, addon := range c.Addons {
+		if addon.Name == "" {
+			errs.add("addon #%d: name is required", i+1)
+		}
+		if seen[addon.Name] {
+			errs.add("addon %q is defined more than once", addon.Name)
+		}
+		seen[addon.Name] = true
+
+		if addon.Version != AddonVersionDefault && addon.Version != AddonVersionLatest && !strings.HasPrefix(addon.Version, "v") {
+			errs.add("addon %q: version %q is not a version like v1.11.4-eksbuild.1 or %s", addon.Name, addon.Version, AddonVersionLatest)
+		}
+
+		if addon.ResolveConflicts != ResolveConflictsNone && addon.ResolveConflicts != ResolveConflictsOverwrite {
+			errs.add("addon %q: resolveConflicts %q is not %s or %s", addon.Name, addon.ResolveConflicts, ResolveConflictsNone, ResolveConflictsOverwrite)
+		}
+
+		if addon.ServiceAccountRoleArn != "" && !strings.HasPrefix(addon.ServiceAccountRoleArn, "arn:") {
+			errs.add("addon %q: serviceAccountRoleArn %q is not an ARN", addon.Name, addon.ServiceAccountRoleArn)
+		}
+
+		if serviceAccount := addon.ServiceAccount; serviceAccount != nil {
+			if addon.ServiceAccountRoleArn != "" {
+				errs.add("addon %q: serviceAccountRoleArn and serviceAccount cannot both be set", addon.Name)
+			}
+			if serviceAccount.Name == "" {
+				errs.add("addon %q: serviceAccount.name is required", addon.Name)
+			}
+			if len(serviceAccount.PolicyArns) == 0 {
+				errs.add("addon %q: serviceAccount.policyArns is required", addon.Name)
+			}
+		}
+	}
+}
+
+func (c *ClusterConfig) validateVersion(errs *validationErrors) {
+	if c.Version == "" {
+		return
+	}
+
+	for _, version := range supportedClusterVersions {
+		if c.Version == version {
+			return
+		}
+	}
+
+	errs.add("clusterVersion %q is not supported (supported: %v)", c.Version, supportedClusterVersions)
+}
+
+func (c *ClusterConfig) validateEndpointAccess(errs *validationErrors) {
+	access := c.EndpointAccess
+
+	if !access.PrivateAccess && !access.PublicAccess {
+		errs.add("clusterEndpointAccess: at least one of privateAccess and publicAccess must be on")
+	}
+
+	// The public endpoint used to be open to the world, now it is only opened to the CIDRs listed
+	if access.PublicAccess && len(access.PublicAccessCidrs) == 0 {
+		errs.add("clusterEndpointAccess.publicAccessCidrs is required when publicAccess is on")
+	}
+	if !access.PublicAccess && len(access.PublicAccessCidrs) > 0 {
+		errs.add("clusterEndpointAccess.publicAccessCidrs cannot be set when publicAccess is off")
+	}
+	if !access.PrivateAccess && len(access.PrivateAccessCidrs) > 0 {
+		errs.add("clusterEndpointAccess.privateAccessCidrs cannot be set when privateAccess is off")
+	}
+
+	for _, accessCidr := range append(append([]string{}, access.PrivateAccessCidrs...), access.PublicAccessCidrs...) {
+		if _, _, err := net.ParseCIDR(accessCidr); err != nil {
+			errs.add("clusterEndpointAccess: %s", err)
+		}
+	}
+}
+
+func (c *ClusterConfig) validateLogging(errs *validationErrors) {
+	if c.Logging == nil {
+		return
+	}
+
+	if len(c.Logging.Types) == 0 {
+		errs.add("clusterLogging.types cannot be empty, leave out clusterLogging to disable logging")
+	}
+
+	seen := map[string]bool{}
+	for _, logType := range c.Logging.Types {
+		valid := false
+		for _, clusterLogType := range clusterLogTypes {
+			valid = valid || logType == clusterLogType
+		}
+
+		if !valid {
+			errs.add("clusterLogging.types: %q is not one of %v", logType, clusterLogTypes)
+		} else if seen[logType] {
+			errs.add("clusterLogging.types lists %q more than once", logType)
+		}
+		seen[logType] = true
+	}
+
+	if !validLogRetention(c.Logging.RetentionDays) {
+		errs.add("clusterLogging.retentionDays %d is not one of %v", c.Logging.RetentionDays, logRetentionDays)
+	}
+
+	if c.Logging.KmsKeyArn != "" && !strings.HasPrefix(c.Logging.KmsKeyArn, "arn:") {
+		errs.add("clusterLogging.kmsKeyArn %q is not an ARN", c.Logging.KmsKeyArn)
+	}
+}
+
+func (c *ClusterConfig) validateFargateProfiles(errs *validationErrors) {
+	seen := map[string]bool{}
+
+	for i, profile := range c.FargateProfiles {
+		if !dnsLabel.MatchString(profile.Name) {
+			errs.add("fargateProfile #%d: name %q must be a lowercase DNS label", i+1, profile.Name)
+		}
+		if seen[profile.Name] {
+			errs.add("fargateProfile %q is defined more than once", profile.Name)
+		}
+		seen[profile.Name] = true
+
+		// Fargate pods never get a public IP, so they can't run in public subnets
+		if profile.Tier != TierPrivate && profile.Tier != TierIsolated {
+			errs.add("fargateProfile %q: tier %q is not %s or %s", profile.Name, profile.Tier, TierPrivate, TierIsolated)
+		}
+
+		if len(profile.Selectors) == 0 || len(profile.Selectors) > 5 {
+			errs.add("fargateProfile %q: needs between 1 and 5 selectors", profile.Name)
+		}
+		for j, selector := range profile.Selectors {
+			if selector.Namespace == "" {
+				errs.add("fargateProfile %q: selector #%d: namespace is required", profile.Name, j+1)
+			}
+			if len(selector.Labels) > 5 {
+				errs.add("fargateProfile %q: selector #%d: has more than 5 labels", profile.Name, j+1)
+			}
+		}
+	}
+
+	// EKS picks one of the profiles at random for a pod matching several
+	for i, profile := range c.FargateProfiles {
+		for _, other := range c.FargateProfiles[i+1:] {
+			for j, selector := range profile.Selectors {
+				for k, otherSelector := range other.Selectors {
+					if selectorsOverlap(selector, otherSelector) {
+						errs.add("fargateProfile %q selector #%d and fargateProfile %q selector #%d can select the same pods",
+							profile.Name, j+1, other.Name, k+1)
+					}
+				}
+			}
+		}
+	}
+}
+
+// selectorsOverlap tells whether a pod could match both selectors: their namespaces have a name in common
+// and the labels they both require can have the same value
+func selectorsOverlap(a, b FargateSelector) bool {
+	if !patternsOverlap(a.Namespace, b.Namespace) {
+		return false
+	}
+	for key, value := range a.Labels {
+		if otherValue, ok := b.Labels[key]; ok && !patternsOverlap(value, otherValue) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// patternsOverlap tells whether some string matches both patterns, which may contain the * and ? wildcards
+func patternsOverlap(a, b string) bool {
+	// overlap[i][j] is whether a[i:] and b[j:] have a match in common
+	overlap := make([][]bool, len(a)+1)
+	for i := range overlap {
+		overlap[i] = make([]bool, len(b)+1)
+	}
+
+	for i := len(a); i >= 0; i-- {
+		for j := len(b); j >= 0; j-- {
+			switch {
+			case i == len(a) && j == len(b):
+				overlap[i][j] = true
+			case i < len(a) && a[i] == '*':
+				// The star matches nothing, or the next character of b
+				overlap[i][j] = overlap[i+1][j] || (j < len(b) && overlap[i][j+1])
+			case j < len(b) && b[j] == '*':
+				overlap[i][j] = overlap[i][j+1] || (i < len(a) && overlap[i+1][j])
+			case i == len(a) || j == len(b):
+				overlap[i][j] = false
+			default:
+				overlap[i][j] = (a[i] == b[j] || a[i] == '?' || b[j] == '?') && overlap[i+1][j+1]
+			}
+		}
+	}
+
+	return overlap[0][0]
+}
+
+func (c *ClusterConfig) validateNodeGroups(errs *validationErrors) {
+	seen := map[string]bool{}
+
+	for i, nodeGroup := range c.NodeGroups {
+		if !dnsLabel.MatchString(nodeGroup.Name) {
+			errs.add("nodeGroup #%d: name %q must be a lowercase DNS label", i+1, nodeGroup.Name)
+		}
+		if seen[nodeGroup.Name] {
+			errs.add("nodeGroup %q is defined more than once", nodeGroup.Name)
+		}
+		seen[nodeGroup.Name] = true
+
+		if nodeGroup.CapacityType != CapacityTypeOnDemand && nodeGroup.CapacityType != CapacityTypeSpot {
+			errs.add("nodeGroup %q: capacityType %q is not %s or %s", nodeGroup.Name, nodeGroup.CapacityType, CapacityTypeOnDemand, CapacityTypeSpot)
+		}
+
+		if nodeGroup.MaxSize < 1 || nodeGroup.MinSize < 0 || nodeGroup.MinSize > nodeGroup.MaxSize {
+			errs.add("nodeGroup %q: minSize %d and maxSize %d must satisfy 0 <= minSize <= maxSize and maxSize >= 1",
+				nodeGroup.Name, nodeGroup.MinSize, nodeGroup.MaxSize)
+		}
+		if *nodeGroup.DesiredSize < nodeGroup.MinSize || *nodeGroup.DesiredSize > nodeGroup.MaxSize {
+			errs.add("nodeGroup %q: desiredSize %d is not between minSize and maxSize", nodeGroup.Name, *nodeGroup.DesiredSize)
+		}
+
+		if nodeGroup.DiskSize < 1 {
+			errs.add("nodeGroup %q: diskSize must be at least 1 GiB", nodeGroup.Name)
+		}
+
+		if !nodeGroup.Tier.valid() {
+			errs.add("nodeGroup %q: tier %q is not one of %v", nodeGroup.Name, nodeGroup.Tier, tiers)
+		}
+
+		for _, taint := range nodeGroup.Taints {
+			if taint.Key == "" {
+				errs.add("nodeGroup %q: taint key is required", nodeGroup.Name)
+			}
+			if _, ok := TaintEffects[taint.Effect]; !ok {
+				errs.add("nodeGroup %q: taint %q has effect %q, expected NoSchedule, NoExecute or PreferNoSchedule",
+					nodeGroup.Name, taint.Key, taint.Effect)
+			}
+		}
+	}
+}
+
+// carveSubnets turns the subnet layout into concrete subnets. Every tier gets a fixed block with room for
+// MaxAZs subnets, carved in the order the tiers are configured, and its subnets are carved out of that block
+// in the order the AZs are listed. Appending to either list keeps the existing CIDRs, reordering moves them.
+func (n *NetworkConfig) carveSubnets(errs *validationErrors) []SubnetConfig {
+	layout := n.SubnetLayout
+
+	if len(layout.AZs) == 0 {
+		errs.add("subnetLayout.azs is required")
+	}
+	if len(layout.Tiers) == 0 {
+		errs.add("subnetLayout.tiers is required")
+	}
+	if layout.MaxAZs < 1 || len(layout.AZs) > layout.MaxAZs {
+		errs.add("subnetLayout: maxAzs %d must be at least the %d AZs listed", layout.MaxAZs, len(layout.AZs))
+		return nil
+	}
+
+	// A tier block holds MaxAZs subnets rounded up to a power of two
+	azBits := 0
+	for 1<<uint(azBits) < layout.MaxAZs {
+		azBits++
+	}
+
+	tierLayouts := []SubnetTierLayout{}
+	tierPrefixLengths := []int{}
+	for _, tierLayout := range layout.Tiers {
+		if !tierLayout.Tier.valid() {
+			errs.add("subnetLayout tier %q is not one of %v", tierLayout.Tier, tiers)
+			continue
+		}
+		tierLayouts = append(tierLayouts, tierLayout)
+		tierPrefixLengths = append(tierPrefixLengths, tierLayout.PrefixLength-azBits)
+	}
+
+	vpcNet, err := cidr.Parse(n.VpcCidr)
+	if err != nil {
+		// Already reported by validate
+		return nil
+	}
+
+	tierBlocks, err := cidr.Carve(vpcNet, tierPrefixLengths)
+	if err != nil {
+		errs.add("subnetLayout: %s", err)
+		return nil
+	}
+
+	subnets := []SubnetConfig{}
+	for i, tierLayout := range tierLayouts {
+		prefixLengths := make([]int, len(layout.AZs))
+		for j := range prefixLengths {
+			prefixLengths[j] = tierLayout.PrefixLength
+		}
+
+		blocks, err := cidr.Carve(tierBlocks[i], prefixLengths)
+		if err != nil {
+			errs.add("subnetLayout tier %q: %s", tierLayout.Tier, err)
+			return nil
+		}
+
+		for j, az := range layout.AZs {
+			subnets = append(subnets, SubnetConfig{
+				Cidr:                blocks[j].String(),
+				AZ:                  az,
+				Tier:                tierLayout.Tier,
+				Tags:                tierLayout.Tags,
+				MapPublicIpOnLaunch: tierLayout.MapPublicIpOnLaunch,
+			})
+		}
+	}
+
+	return subnets
+}
@@ -0,0 +1,446 @@
+package stackconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectorsOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		a    FargateSelector
+		b    FargateSelector
+		want bool
+	}{
+		{
+			name: "same namespace",
+			a:    FargateSelector{Namespace: "kube-system"},
+			b:    FargateSelector{Namespace: "kube-system"},
+			want: true,
+		},
+		{
+			name: "different namespaces",
+			a:    FargateSelector{Namespace: "traefik"},
+			b:    FargateSelector{Namespace: "cert-manager"},
+			want: false,
+		},
+		{
+			name: "wildcard covering a namespace",
+			a:    FargateSelector{Namespace: "apps-*"},
+			b:    FargateSelector{Namespace: "apps-billing"},
+			want: true,
+		},
+		{
+			name: "wildcards with a name in common",
+			a:    FargateSelector{Namespace: "apps-*"},
+			b:    FargateSelector{Namespace: "*-billing"},
+			want: true,
+		},
+		{
+			name: "wildcards without a name in common",
+			a:    FargateSelector{Namespace: "apps-*"},
+			b:    FargateSelector{Namespace: "batch-?"},
+			want: false,
+		},
+		{
+			name: "question mark standing for a single character",
+			a:    FargateSelector{Namespace: "team-?"},
+			b:    FargateSelector{Namespace: "team-ab"},
+			want: false,
+		},
+		{
+			name: "same namespace, conflicting label values",
+			a:    FargateSelector{Namespace: "apps", Labels: map[string]string{"compute": "fargate"}},
+			b:    FargateSelector{Namespace: "apps", Labels: map[string]string{"compute": "spot"}},
+			want: false,
+		},
+		{
+			name: "same namespace, different label keys",
+			a:    FargateSelector{Namespace: "apps", Labels: map[string]string{"compute": "fargate"}},
+			b:    FargateSelector{Namespace: "apps", Labels: map[string]string{"team": "billing"}},
+			want: true,
+		},
+		{
+			name: "namespace without labels and with labels",
+			a:    FargateSelector{Namespace: "kube-system"},
+			b:    FargateSelector{Namespace: "kube-system", Labels: map[string]string{"k8s-app": "kube-dns"}},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectorsOverlap(tt.a, tt.b); got != tt.want {
+				t.Errorf("selectorsOverlap(%+v, %+v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := selectorsOverlap(tt.b, tt.a); got != tt.want {
+				t.Errorf("selectorsOverlap(%+v, %+v) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoreDNSFargateProfile(t *testing.T) {
+	cluster := ClusterConfig{
+		FargateProfiles: []FargateProfileConfig{
+			{Name: "apps", Selectors: []FargateSelector{{Namespace: "apps-*"}}},
+			{Name: "dns", Selectors: []FargateSelector{{Namespace: "kube-system", Labels: map[string]string{"k8s-app": "kube-dns"}}}},
+		},
+	}
+	if got := cluster.CoreDNSFargateProfile(); got != "dns" {
+		t.Errorf("CoreDNSFargateProfile() = %q, want dns", got)
+	}
+
+	cluster.FargateProfiles[1].Selectors[0].Labels["k8s-app"] = "metrics-server"
+	if got := cluster.CoreDNSFargateProfile(); got != "" {
+		t.Errorf("CoreDNSFargateProfile() = %q, want none", got)
+	}
+}
+
+func TestCarveSubnetsAppendingAZ(t *testing.T) {
+	carve := func(azs []string) map[string]string {
+		n := &NetworkConfig{
+			VpcCidr: "10.0.0.0/16",
+			SubnetLayout: &SubnetLayout{
+				AZs:    azs,
+				MaxAZs: 4,
+				Tiers:  []SubnetTierLayout{{Tier: TierPublic, PrefixLength: 24}, {Tier: TierPrivate, PrefixLength: 24}},
+			},
+		}
+		errs := &validationErrors{}
+		subnets := n.carveSubnets(errs)
+		if err := errs.err(); err != nil {
+			t.Fatal(err)
+		}
+
+		cidrs := map[string]string{}
+		for _, subnet := range subnets {
+			cidrs[string(subnet.Tier)+"-"+subnet.AZ] = subnet.Cidr
+		}
+		return cidrs
+	}
+
+	before := carve([]string{"us-east-1a", "us-east-1c"})
+	after := carve([]string{"us-east-1a", "us-east-1c", "us-east-1b"})
+
+	if before["private-us-east-1a"] != "10.0.4.0/24" {
+		t.Errorf("private-us-east-1a = %s, want 10.0.4.0/24", before["private-us-east-1a"])
+	}
+	for name, cidr := range before {
+		if after[name] != cidr {
+			t.Errorf("appending an AZ moved %s from %s to %s", name, cidr, after[name])
+		}
+	}
+	if after["private-us-east-1b"] != "10.0.6.0/24" {
+		t.Errorf("private-us-east-1b = %s, want 10.0.6.0/24", after["private-us-east-1b"])
+	}
+}
+
+func TestIpv6SubnetIndex(t *testing.T) {
+	n := &NetworkConfig{
+		SubnetLayout: &SubnetLayout{AZs: []string{"us-east-1a", "us-east-1c", "us-east-1b"}},
+	}
+
+	tests := []struct {
+		tier Tier
+		az   string
+		want int
+	}{
+		{tier: TierPublic, az: "us-east-1a", want: 0},
+		{tier: TierPublic, az: "us-east-1b", want: 2},
+		{tier: TierPrivate, az: "us-east-1a", want: 64},
+		{tier: TierPrivate, az: "us-east-1c", want: 65},
+		{tier: TierIsolated, az: "us-east-1b", want: 130},
+	}
+
+	for _, tt := range tests {
+		if got := n.Ipv6SubnetIndex(SubnetConfig{Tier: tt.tier, AZ: tt.az}); got != tt.want {
+			t.Errorf("Ipv6SubnetIndex(%s, %s) = %d, want %d", tt.tier, tt.az, got, tt.want)
+		}
+	}
+}
+
+// validNetwork is a network every validation accepts, test cases break one thing in it
+func validNetwork() *NetworkConfig {
+	return &NetworkConfig{
+		VpcCidr: "10.0.0.0/16",
+		NatMode: NatModeSingle,
+		Subnets: []SubnetConfig{
+			{Name: "public-a", Cidr: "10.0.1.0/24", AZ: "us-east-1a", Tier: TierPublic},
+			{Name: "public-c", Cidr: "10.0.2.0/24", AZ: "us-east-1c", Tier: TierPublic},
+			{Name: "private-a", Cidr: "10.0.3.0/24", AZ: "us-east-1a", Tier: TierPrivate},
+			{Name: "private-c", Cidr: "10.0.4.0/24", AZ: "us-east-1c", Tier: TierPrivate},
+		},
+	}
+}
+
+// validCluster is a cluster every validation accepts, test cases break one thing in it
+func validCluster() *ClusterConfig {
+	return &ClusterConfig{
+		Name:           "u-test-k8s-cluster",
+		Version:        "1.23",
+		EndpointAccess: EndpointAccessConfig{PrivateAccess: true},
+		FargateProfiles: []FargateProfileConfig{
+			{Name: "system", Tier: TierPrivate, Selectors: []FargateSelector{{Namespace: "kube-system"}}},
+			{Name: "apps", Tier: TierPrivate, Selectors: []FargateSelector{{Namespace: "apps-*"}}},
+		},
+	}
+}
+
+// checkErrors fails unless errs holds exactly one message containing each of want
+func checkErrors(t *testing.T, errs *validationErrors, want []string) {
+	t.Helper()
+
+	if len(errs.messages) != len(want) {
+		t.Fatalf("got errors %q, want %d errors containing %q", errs.messages, len(want), want)
+	}
+	for _, substring := range want {
+		found := false
+		for _, message := range errs.messages {
+			if strings.Contains(message, substring) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("got errors %q, want one containing %q", errs.messages, substring)
+		}
+	}
+}
+
+func TestNetworkConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(n *NetworkConfig)
+		want   []string
+	}{
+		{
+			name:   "valid",
+			modify: func(n *NetworkConfig) {},
+		},
+		{
+			name:   "vpcCidr with host bits",
+			modify: func(n *NetworkConfig) { n.VpcCidr = "10.0.0.1/16" },
+			want:   []string{"vpcCidr:"},
+		},
+		{
+			name:   "subnet outside the VPC",
+			modify: func(n *NetworkConfig) { n.Subnets[3].Cidr = "10.1.4.0/24" },
+			want:   []string{`subnet "private-c" cidr 10.1.4.0/24 is not inside vpcCidr`},
+		},
+		{
+			name:   "overlapping subnets",
+			modify: func(n *NetworkConfig) { n.Subnets[3].Cidr = "10.0.3.128/25" },
+			want:   []string{`subnet "private-c" cidr 10.0.3.128/25 overlaps subnet "private-a"`},
+		},
+		{
+			name:   "two subnets in the same tier and AZ",
+			modify: func(n *NetworkConfig) { n.Subnets[3].AZ = "us-east-1a" },
+			want:   []string{"only one subnet per tier and AZ"},
+		},
+		{
+			name:   "two subnets with the same name",
+			modify: func(n *NetworkConfig) { n.Subnets[3].Name = "private-a" },
+			want:   []string{`subnet name "private-a" is used more than once`},
+		},
+		{
+			name:   "unknown tier",
+			modify: func(n *NetworkConfig) { n.Subnets[3].Tier = "dmz" },
+			want:   []string{`has tier "dmz"`},
+		},
+		{
+			name: "per-az NAT without a public subnet in every private AZ",
+			modify: func(n *NetworkConfig) {
+				n.NatMode = NatModePerAZ
+				n.Subnets = append(n.Subnets, SubnetConfig{Name: "private-b", Cidr: "10.0.5.0/24", AZ: "us-east-1b", Tier: TierPrivate})
+			},
+			want: []string{"needs a public subnet in us-east-1b"},
+		},
+		{
+			name: "single NAT without public subnets",
+			modify: func(n *NetworkConfig) {
+				n.Subnets = n.Subnets[2:]
+			},
+			want: []string{"natMode single needs at least one public subnet"},
+		},
+		{
+			name: "remote network overlapping the VPC and itself",
+			modify: func(n *NetworkConfig) {
+				n.RemoteNetwork = &RemoteNetworkConfig{
+					Type:      RemoteNetworkPeering,
+					PeerVpcId: "vpc-0123456789abcdef0",
+					Cidrs:     []string{"10.0.128.0/17", "172.16.0.0/16", "172.16.8.0/24"},
+				}
+			},
+			want: []string{
+				"remoteNetwork cidr 10.0.128.0/17 overlaps vpcCidr",
+				"remoteNetwork cidr 172.16.8.0/24 overlaps remoteNetwork cidr 172.16.0.0/16",
+			},
+		},
+		{
+			name: "transit gateway without its id",
+			modify: func(n *NetworkConfig) {
+				n.RemoteNetwork = &RemoteNetworkConfig{Type: RemoteNetworkTransitGateway, Cidrs: []string{"172.16.0.0/16"}}
+			},
+			want: []string{"remoteNetwork.transitGatewayId is required"},
+		},
+		{
+			name: "unparseable existing VPC",
+			modify: func(n *NetworkConfig) {
+				*n = NetworkConfig{unreadable: true}
+			},
+		},
+		{
+			name: "existing VPC with both id and tags",
+			modify: func(n *NetworkConfig) {
+				*n = NetworkConfig{ExistingVpc: &ExistingVpcConfig{Id: "vpc-0123456789abcdef0", Tags: map[string]string{"Name": "main"}, TierTag: "Tier"}}
+			},
+			want: []string{"existingVpc: exactly one of id and tags must be set"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := validNetwork()
+			tt.modify(n)
+
+			errs := &validationErrors{}
+			n.validate(errs)
+			checkErrors(t, errs, tt.want)
+		})
+	}
+}
+
+func TestClusterConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *ClusterConfig)
+		want   []string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *ClusterConfig) {},
+		},
+		{
+			name:   "unsupported version",
+			modify: func(c *ClusterConfig) { c.Version = "1.19" },
+			want:   []string{`clusterVersion "1.19" is not supported`},
+		},
+		{
+			name:   "public endpoint without CIDRs",
+			modify: func(c *ClusterConfig) { c.EndpointAccess.PublicAccess = true },
+			want:   []string{"publicAccessCidrs is required when publicAccess is on"},
+		},
+		{
+			name:   "no endpoint",
+			modify: func(c *ClusterConfig) { c.EndpointAccess.PrivateAccess = false },
+			want:   []string{"at least one of privateAccess and publicAccess must be on"},
+		},
+		{
+			name:   "secrets key that isn't an ARN",
+			modify: func(c *ClusterConfig) { c.SecretsKmsKeyArn = "alias/secrets" },
+			want:   []string{`secretsKmsKeyArn "alias/secrets" is not an ARN`},
+		},
+		{
+			name: "node group sizes out of order",
+			modify: func(c *ClusterConfig) {
+				desiredSize := 2
+				c.NodeGroups = []NodeGroupConfig{{
+					Name: "system", CapacityType: CapacityTypeOnDemand, MinSize: 3, MaxSize: 1, DesiredSize: &desiredSize,
+					DiskSize: 20, Tier: TierPrivate,
+				}}
+			},
+			want: []string{"minSize 3 and maxSize 1 must satisfy", "desiredSize 2 is not between minSize and maxSize"},
+		},
+		{
+			name: "add-on with an unknown conflict resolution",
+			modify: func(c *ClusterConfig) {
+				c.Addons = []AddonConfig{{Name: "vpc-cni", ResolveConflicts: "preserve"}}
+			},
+			want: []string{`resolveConflicts "preserve" is not none or overwrite`},
+		},
+		{
+			name: "cluster access for a node group",
+			modify: func(c *ClusterConfig) {
+				c.Access.Roles = []IamIdentityMapping{{Arn: "arn:aws:iam::123456789012:role/admin", Username: "admin", Groups: []string{"system:nodes"}}}
+				c.Access.Users = []IamIdentityMapping{{Arn: "arn:aws:iam::123456789012:role/jane", Username: "jane"}}
+			},
+			want: []string{"cannot be in group system:nodes", "is not an IAM user ARN"},
+		},
+		{
+			name: "overlapping Fargate profiles",
+			modify: func(c *ClusterConfig) {
+				c.FargateProfiles[1].Selectors = append(c.FargateProfiles[1].Selectors, FargateSelector{Namespace: "kube-*"})
+			},
+			want: []string{`fargateProfile "system" selector #1 and fargateProfile "apps" selector #2 can select the same pods`},
+		},
+		{
+			name:   "nowhere to run pods",
+			modify: func(c *ClusterConfig) { c.FargateProfiles = nil },
+			want:   []string{"at least one Fargate profile or node group"},
+		},
+		{
+			name: "nowhere to run CoreDNS",
+			modify: func(c *ClusterConfig) {
+				c.FargateProfiles = c.FargateProfiles[1:]
+			},
+			want: []string{"CoreDNS needs a Fargate profile"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validCluster()
+			tt.modify(c)
+
+			errs := &validationErrors{}
+			c.validate(errs)
+			checkErrors(t, errs, tt.want)
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := &StackConfig{
+		Network: *validNetwork(),
+		Cluster: *validCluster(),
+	}
+	cfg.Network.Subnets[3].Cidr = "10.1.4.0/24"
+	cfg.Network.NatMode = "gateway"
+	cfg.Cluster.Version = "1.19"
+	cfg.Cluster.EndpointAccess.PublicAccess = true
+
+	errs := &validationErrors{}
+	cfg.validate(errs)
+
+	err := errs.err()
+	if err == nil {
+		t.Fatal("validate accepted a configuration with four mistakes")
+	}
+	for _, want := range []string{
+		`subnet "private-c" cidr 10.1.4.0/24 is not inside vpcCidr`,
+		`natMode "gateway" is not one of`,
+		`clusterVersion "1.19" is not supported`,
+		"publicAccessCidrs is required when publicAccess is on",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+	if len(errs.messages) != 4 {
+		t.Errorf("got %d errors, want 4: %q", len(errs.messages), errs.messages)
+	}
+}
+
+func TestValidateSkipsUnreadableExistingVpc(t *testing.T) {
+	cfg := &StackConfig{
+		Network: NetworkConfig{unreadable: true},
+		Cluster: *validCluster(),
+	}
+	cfg.Cluster.Version = "1.19"
+
+	errs := &validationErrors{}
+	cfg.validate(errs)
+
+	// Only the cluster problem, nothing about NAT or Fargate subnets of a network that wasn't read
+	checkErrors(t, errs, []string{`clusterVersion "1.19" is not supported`})
+}
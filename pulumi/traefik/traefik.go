@@ -1,7 +1,9 @@
 package traefik
 
 import (
-	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"uptactics/eks"
+	"uptactics/naming"
+
 	appsv1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apps/v1"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/core/v1"
 	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
@@ -9,15 +11,15 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func CreateTraefikIngress(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
+func CreateTraefikIngress(ctx *pulumi.Context, namer *naming.Namer, cluster *eks.Cluster) error {
 	// Create Traefik Namespace
-	_, err := corev1.NewNamespace(ctx, "traefik-namespace", &corev1.NamespaceArgs{
+	_, err := corev1.NewNamespace(ctx, namer.Name("traefik-namespace"), &corev1.NamespaceArgs{
 		ApiVersion: pulumi.String("v1"),
 		Kind:       pulumi.String("Namespace"),
 		Metadata: metav1.ObjectMetaArgs{
 			Name: pulumi.String("traefik"),
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	}, pulumi.Provider(cluster.Provider), pulumi.DependsOn([]pulumi.Resource{cluster.Cluster}), naming.Aliases("traefik-namespace"))
 	if err != nil {
 		return err
 	}
@@ -25,7 +27,7 @@ func CreateTraefikIngress(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
 	traefikName := "traefik-ingress-controller"
 
 	// Create ClusterRole
-	_, err = rbacv1.NewClusterRole(ctx, traefikName+"-cluster-role", &rbacv1.ClusterRoleArgs{
+	_, err = rbacv1.NewClusterRole(ctx, namer.Name(traefikName+"-cluster-role"), &rbacv1.ClusterRoleArgs{
 		Kind:       pulumi.String("ClusterRole"),
 		ApiVersion: pulumi.String("rbac.authorization.k8s.io/v1"),
 		Metadata: metav1.ObjectMetaArgs{
@@ -75,13 +77,13 @@ func CreateTraefikIngress(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
 				},
 			},
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	}, pulumi.Provider(cluster.Provider), pulumi.DependsOn([]pulumi.Resource{cluster.Cluster}), naming.Aliases(traefikName+"-cluster-role"))
 	if err != nil {
 		return err
 	}
 
 	// Create ClusterRoleBinding
-	_, err = rbacv1.NewClusterRoleBinding(ctx, traefikName+"-cluster-role-binding", &rbacv1.ClusterRoleBindingArgs{
+	_, err = rbacv1.NewClusterRoleBinding(ctx, namer.Name(traefikName+"-cluster-role-binding"), &rbacv1.ClusterRoleBindingArgs{
 		Kind:       pulumi.String("ClusterRoleBinding"),
 		ApiVersion: pulumi.String("rbac.authorization.k8s.io/v1"),
 		Metadata: metav1.ObjectMetaArgs{
@@ -100,26 +102,26 @@ func CreateTraefikIngress(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
 				Name:      pulumi.String(traefikName),
 			},
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	}, pulumi.Provider(cluster.Provider), pulumi.DependsOn([]pulumi.Resource{cluster.Cluster}), naming.Aliases(traefikName+"-cluster-role-binding"))
 	if err != nil {
 		return err
 	}
 
 	// Create ServiceAccount
-	_, err = corev1.NewServiceAccount(ctx, traefikName+"-service-account", &corev1.ServiceAccountArgs{
+	_, err = corev1.NewServiceAccount(ctx, namer.Name(traefikName+"-service-account"), &corev1.ServiceAccountArgs{
 		Kind:       pulumi.String("ServiceAccount"),
 		ApiVersion: pulumi.String("v1"),
 		Metadata: metav1.ObjectMetaArgs{
 			Namespace: pulumi.String("traefik"),
 			Name:      pulumi.String(traefikName),
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	}, pulumi.Provider(cluster.Provider), pulumi.DependsOn([]pulumi.Resource{cluster.Cluster}), naming.Aliases(traefikName+"-service-account"))
 	if err != nil {
 		return err
 	}
 
 	// Create Deployment
-	_, err = appsv1.NewDeployment(ctx, traefikName+"-deployment", &appsv1.DeploymentArgs{
+	_, err = appsv1.NewDeployment(ctx, namer.Name(traefikName+"-deployment"), &appsv1.DeploymentArgs{
 		Kind:       pulumi.String("Deployment"),
 		ApiVersion: pulumi.String("apps/v1"),
 		Metadata: metav1.ObjectMetaArgs{
@@ -179,7 +181,7 @@ func CreateTraefikIngress(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
 				},
 			},
 		},
-	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	}, pulumi.Provider(cluster.Provider), pulumi.DependsOn([]pulumi.Resource{cluster.Cluster}), naming.Aliases(traefikName+"-deployment"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,57 @@
+package vpc
+
+import (
+	"fmt"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/route53"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createPrivateDns creates a private hosted zone for the internal domain, only resolvable from inside
+// the VPC, and makes the domain the search domain of the VPC through a DHCP options set
+func createPrivateDns(ctx *pulumi.Context, cfg *stackconfig.StackConfig, vpc *ec2.Vpc) (*route53.Zone, error) {
+	domain := cfg.Network.PrivateDns.Domain
+	zoneName := cfg.Namer.Name("zone", "private")
+
+	zone, err := route53.NewZone(ctx, zoneName, &route53.ZoneArgs{
+		Name:    pulumi.String(domain),
+		Comment: pulumi.String(fmt.Sprintf("Internal domain of %s", cfg.Namer.Name("vpc"))),
+		Vpcs: route53.ZoneVpcArray{
+			route53.ZoneVpcArgs{
+				VpcId: vpc.ID(),
+			},
+		},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(zoneName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the Amazon-provided resolver, which also answers for the private zone
+	dhcpName := cfg.Namer.Name("dhcp")
+	dhcpOptions, err := ec2.NewVpcDhcpOptions(ctx, dhcpName, &ec2.VpcDhcpOptionsArgs{
+		DomainName:        pulumi.String(domain),
+		DomainNameServers: pulumi.StringArray{pulumi.String("AmazonProvidedDNS")},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(dhcpName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ec2.NewVpcDhcpOptionsAssociation(ctx, dhcpName, &ec2.VpcDhcpOptionsAssociationArgs{
+		VpcId:         vpc.ID(),
+		DhcpOptionsId: dhcpOptions.ID(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return zone, nil
+}
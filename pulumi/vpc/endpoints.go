@@ -0,0 +1,106 @@
+package vpc
+
+import (
+	"fmt"
+	"strings"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createEndpoints keeps traffic to AWS services inside the VPC instead of sending it through the NAT.
+// S3 gets a free gateway endpoint on the given route tables, every other service an interface endpoint
+// in one private subnet per AZ. The endpoints are returned keyed by service name.
+func createEndpoints(ctx *pulumi.Context, cfg *stackconfig.StackConfig, vpc *ec2.Vpc, privateSubnets []subnet, routeTables []*ec2.RouteTable) (map[string]*ec2.VpcEndpoint, error) {
+	endpoints := cfg.Network.Endpoints
+	namer := cfg.Namer
+	vpcEndpoints := map[string]*ec2.VpcEndpoint{}
+
+	region, err := aws.GetRegion(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create S3 Gateway Endpoint
+	if endpoints.S3Gateway {
+		routeTableIds := pulumi.StringArray{}
+		for _, routeTable := range routeTables {
+			routeTableIds = append(routeTableIds, routeTable.ID())
+		}
+
+		s3EndpointName := namer.Name("vpce", "s3")
+		s3Endpoint, err := ec2.NewVpcEndpoint(ctx, s3EndpointName, &ec2.VpcEndpointArgs{
+			VpcId:           vpc.ID(),
+			ServiceName:     pulumi.String(fmt.Sprintf("com.amazonaws.%s.s3", region.Name)),
+			VpcEndpointType: pulumi.String("Gateway"),
+			RouteTableIds:   routeTableIds,
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(s3EndpointName),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		vpcEndpoints["s3"] = s3Endpoint
+	}
+
+	if len(endpoints.Services) == 0 {
+		return vpcEndpoints, nil
+	}
+
+	// Create Interface Endpoint Security Group, accepting HTTPS from anywhere in the VPC
+	sgName := namer.Name("vpce", "sg")
+	sg, err := ec2.NewSecurityGroup(ctx, sgName, &ec2.SecurityGroupArgs{
+		VpcId:       vpc.ID(),
+		Description: pulumi.String("Interface VPC endpoints"),
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(443),
+				ToPort:     pulumi.Int(443),
+				CidrBlocks: pulumi.StringArray{pulumi.String(cfg.Network.VpcCidr)},
+			},
+		},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(sgName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// An interface endpoint accepts at most one subnet per AZ
+	subnetIds := pulumi.StringArray{}
+	seenAZs := map[string]bool{}
+	for _, privateSubnet := range privateSubnets {
+		if !seenAZs[privateSubnet.az] {
+			subnetIds = append(subnetIds, privateSubnet.id)
+			seenAZs[privateSubnet.az] = true
+		}
+	}
+
+	// Create Interface Endpoints
+	for _, service := range endpoints.Services {
+		endpointName := namer.Name("vpce", strings.ReplaceAll(service, ".", "-"))
+		endpoint, err := ec2.NewVpcEndpoint(ctx, endpointName, &ec2.VpcEndpointArgs{
+			VpcId:             vpc.ID(),
+			ServiceName:       pulumi.String(fmt.Sprintf("com.amazonaws.%s.%s", region.Name, service)),
+			VpcEndpointType:   pulumi.String("Interface"),
+			PrivateDnsEnabled: pulumi.Bool(true),
+			SubnetIds:         subnetIds,
+			SecurityGroupIds:  pulumi.StringArray{sg.ID()},
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(endpointName),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		vpcEndpoints[service] = endpoint
+	}
+
+	return vpcEndpoints, nil
+}
@@ -0,0 +1,198 @@
+package vpc
+
+import (
+	"fmt"
+	"sort"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// importNetwork reads a VPC managed outside this program, e.g. by the Terraform in uptactics/, into a Network.
+// Its subnets are classified by the value of the configured tier tag, and their route tables, internet
+// gateway and NAT gateways are read along with them. Nothing is created in the VPC apart from flow logs
+// and private DNS.
+func importNetwork(ctx *pulumi.Context, cfg *stackconfig.StackConfig) (*Network, error) {
+	existingVpc := cfg.Network.ExistingVpc
+	network := newNetwork()
+
+	vpcArgs := &ec2.LookupVpcArgs{Tags: existingVpc.Tags}
+	if existingVpc.Id != "" {
+		vpcArgs.Id = pulumi.StringRef(existingVpc.Id)
+	}
+
+	found, err := ec2.LookupVpc(ctx, vpcArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read resources are named by their ID, the names of the resources this program creates stay free
+	vpc, err := ec2.GetVpc(ctx, found.Id, pulumi.ID(found.Id), nil)
+	if err != nil {
+		return nil, err
+	}
+	network.Vpc = vpc
+
+	// Create Flow Logs
+	if cfg.Network.FlowLogs != nil {
+		err = createFlowLogs(ctx, cfg, vpc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create Private DNS
+	if cfg.Network.PrivateDns != nil {
+		network.PrivateZone, err = createPrivateDns(ctx, cfg, vpc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Read Subnets
+	subnetIds, err := ec2.GetSubnets(ctx, &ec2.GetSubnetsArgs{
+		Filters: []ec2.GetSubnetsFilter{
+			{
+				Name:   "vpc-id",
+				Values: []string{found.Id},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(subnetIds.Ids)
+
+	subnetAZs := map[string]string{}
+	subnetTiers := map[string]stackconfig.Tier{}
+
+	for _, subnetId := range subnetIds.Ids {
+		foundSubnet, err := ec2.LookupSubnet(ctx, &ec2.LookupSubnetArgs{Id: pulumi.StringRef(subnetId)})
+		if err != nil {
+			return nil, err
+		}
+		subnetAZs[subnetId] = foundSubnet.AvailabilityZone
+
+		tierTag, ok := foundSubnet.Tags[existingVpc.TierTag]
+		if !ok {
+			continue
+		}
+
+		tier := stackconfig.Tier(tierTag)
+		tierSubnets, ok := network.Subnets[tier]
+		if !ok {
+			return nil, fmt.Errorf("subnet %s has %s tag %q, expected %s, %s or %s", subnetId, existingVpc.TierTag, tierTag,
+				stackconfig.TierPublic, stackconfig.TierPrivate, stackconfig.TierIsolated)
+		}
+		if _, ok := tierSubnets[foundSubnet.AvailabilityZone]; ok {
+			return nil, fmt.Errorf("vpc %s has more than one %s subnet in %s, only one subnet per tier and AZ is supported",
+				found.Id, tier, foundSubnet.AvailabilityZone)
+		}
+
+		ec2Subnet, err := ec2.GetSubnet(ctx, subnetId, pulumi.ID(subnetId), nil)
+		if err != nil {
+			return nil, err
+		}
+		tierSubnets[foundSubnet.AvailabilityZone] = ec2Subnet
+		subnetTiers[subnetId] = tier
+	}
+
+	if len(network.Subnets[stackconfig.TierPrivate]) == 0 {
+		return nil, fmt.Errorf("vpc %s has no subnet tagged %s=%s", found.Id, existingVpc.TierTag, stackconfig.TierPrivate)
+	}
+
+	// Read Route Tables. Subnets need an explicit route table association to be found.
+	routeTables := map[string]*ec2.RouteTable{}
+
+	for _, subnetId := range subnetIds.Ids {
+		tier, ok := subnetTiers[subnetId]
+		if !ok {
+			continue
+		}
+
+		foundRT, err := ec2.LookupRouteTable(ctx, &ec2.LookupRouteTableArgs{SubnetId: pulumi.StringRef(subnetId)})
+		if err != nil {
+			return nil, err
+		}
+
+		routeTable, ok := routeTables[foundRT.Id]
+		if !ok {
+			routeTable, err = ec2.GetRouteTable(ctx, foundRT.Id, pulumi.ID(foundRT.Id), nil)
+			if err != nil {
+				return nil, err
+			}
+			routeTables[foundRT.Id] = routeTable
+		}
+
+		// The network has one public and one isolated route table
+		switch tier {
+		case stackconfig.TierPublic:
+			if network.PublicRouteTable != nil && network.PublicRouteTable != routeTable {
+				return nil, fmt.Errorf("public subnets of vpc %s use more than one route table", found.Id)
+			}
+			network.PublicRouteTable = routeTable
+		case stackconfig.TierPrivate:
+			network.PrivateRouteTables[subnetAZs[subnetId]] = routeTable
+		case stackconfig.TierIsolated:
+			if network.IsolatedRouteTable != nil && network.IsolatedRouteTable != routeTable {
+				return nil, fmt.Errorf("isolated subnets of vpc %s use more than one route table", found.Id)
+			}
+			network.IsolatedRouteTable = routeTable
+		}
+	}
+
+	// Read IGW
+	if len(network.Subnets[stackconfig.TierPublic]) > 0 {
+		foundIgw, err := ec2.LookupInternetGateway(ctx, &ec2.LookupInternetGatewayArgs{
+			Filters: []ec2.GetInternetGatewayFilter{
+				{
+					Name:   "attachment.vpc-id",
+					Values: []string{found.Id},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		igw, err := ec2.GetInternetGateway(ctx, foundIgw.Id, pulumi.ID(foundIgw.Id), nil)
+		if err != nil {
+			return nil, err
+		}
+		network.InternetGateway = igw
+	}
+
+	// Read NAT Gateways
+	natGatewayIds, err := ec2.GetNatGateways(ctx, &ec2.GetNatGatewaysArgs{
+		VpcId: pulumi.StringRef(found.Id),
+		Filters: []ec2.GetNatGatewaysFilter{
+			{
+				Name:   "state",
+				Values: []string{"available"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(natGatewayIds.Ids)
+
+	for _, natGatewayId := range natGatewayIds.Ids {
+		foundNgw, err := ec2.LookupNatGateway(ctx, &ec2.LookupNatGatewayArgs{Id: pulumi.StringRef(natGatewayId)})
+		if err != nil {
+			return nil, err
+		}
+
+		ngw, err := ec2.GetNatGateway(ctx, natGatewayId, pulumi.ID(natGatewayId), nil)
+		if err != nil {
+			return nil, err
+		}
+		network.NatGateways[subnetAZs[foundNgw.SubnetId]] = ngw
+	}
+
+	network.export(ctx)
+
+	return network, nil
+}
@@ -0,0 +1,152 @@
+package vpc
+
+import (
+	"fmt"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// The default fields plus the ones we query during incidents: the original packet addresses behind
+// the NAT and load balancers, which way the flow went and which path it took out of the VPC
+const flowLogFormat = "${version} ${account-id} ${interface-id} ${srcaddr} ${dstaddr} ${srcport} ${dstport} " +
+	"${protocol} ${packets} ${bytes} ${start} ${end} ${action} ${log-status} " +
+	"${vpc-id} ${subnet-id} ${az-id} ${instance-id} ${tcp-flags} ${type} " +
+	"${pkt-srcaddr} ${pkt-dstaddr} ${flow-direction} ${traffic-path}"
+
+// createFlowLogs records the traffic of the whole VPC to CloudWatch Logs or S3
+func createFlowLogs(ctx *pulumi.Context, cfg *stackconfig.StackConfig, vpc *ec2.Vpc) error {
+	flowLogs := cfg.Network.FlowLogs
+	flowLogName := cfg.Namer.Name("flow-logs")
+
+	flowLogArgs := &ec2.FlowLogArgs{
+		VpcId:       vpc.ID(),
+		TrafficType: pulumi.String(flowLogs.TrafficType),
+		LogFormat:   pulumi.String(flowLogFormat),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(flowLogName),
+		},
+	}
+
+	switch flowLogs.Destination {
+	case stackconfig.FlowLogDestinationCloudWatch:
+		logGroup, err := cloudwatch.NewLogGroup(ctx, flowLogName, &cloudwatch.LogGroupArgs{
+			Name:            pulumi.String(fmt.Sprintf("/aws/vpc/%s/flow-logs", cfg.Namer.Name("vpc"))),
+			RetentionInDays: pulumi.Int(flowLogs.RetentionDays),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(flowLogName),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Create Flow Logs Role that lets the VPC flow logs service write into the log group
+		flowLogRole, err := iam.NewRole(ctx, flowLogName+"-role", &iam.RoleArgs{
+			AssumeRolePolicy: pulumi.String(`{
+			    "Version": "2012-10-17",
+			    "Statement": [{
+			        "Effect": "Allow",
+			        "Principal": {
+			            "Service": "vpc-flow-logs.amazonaws.com"
+			        },
+			        "Action": "sts:AssumeRole"
+			    }]
+			}`),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(flowLogName + "-role"),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = iam.NewRolePolicy(ctx, flowLogName+"-role-policy", &iam.RolePolicyArgs{
+			Role: flowLogRole.Name,
+			Policy: pulumi.Sprintf(`{
+			    "Version": "2012-10-17",
+			    "Statement": [{
+			        "Effect": "Allow",
+			        "Action": [
+			            "logs:CreateLogStream",
+			            "logs:PutLogEvents",
+			            "logs:DescribeLogGroups",
+			            "logs:DescribeLogStreams"
+			        ],
+			        "Resource": ["%s", "%s:*"]
+			    }]
+			}`, logGroup.Arn, logGroup.Arn),
+		})
+		if err != nil {
+			return err
+		}
+
+		flowLogArgs.LogDestinationType = pulumi.String("cloud-watch-logs")
+		flowLogArgs.LogDestination = logGroup.Arn
+		flowLogArgs.IamRoleArn = flowLogRole.Arn
+
+	case stackconfig.FlowLogDestinationS3:
+		bucket, err := s3.NewBucketV2(ctx, flowLogName, &s3.BucketV2Args{
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(flowLogName),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = s3.NewBucketPublicAccessBlock(ctx, flowLogName, &s3.BucketPublicAccessBlockArgs{
+			Bucket:                bucket.ID(),
+			BlockPublicAcls:       pulumi.Bool(true),
+			BlockPublicPolicy:     pulumi.Bool(true),
+			IgnorePublicAcls:      pulumi.Bool(true),
+			RestrictPublicBuckets: pulumi.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+
+		// Move logs to infrequent access once they are unlikely to be queried, then expire them
+		rule := s3.BucketLifecycleConfigurationV2RuleArgs{
+			Id:     pulumi.String("expire-flow-logs"),
+			Status: pulumi.String("Enabled"),
+			Expiration: &s3.BucketLifecycleConfigurationV2RuleExpirationArgs{
+				Days: pulumi.Int(flowLogs.RetentionDays),
+			},
+			AbortIncompleteMultipartUpload: &s3.BucketLifecycleConfigurationV2RuleAbortIncompleteMultipartUploadArgs{
+				DaysAfterInitiation: pulumi.Int(1),
+			},
+		}
+		if flowLogs.RetentionDays > 30 {
+			rule.Transitions = s3.BucketLifecycleConfigurationV2RuleTransitionArray{
+				s3.BucketLifecycleConfigurationV2RuleTransitionArgs{
+					Days:         pulumi.Int(30),
+					StorageClass: pulumi.String("STANDARD_IA"),
+				},
+			}
+		}
+
+		_, err = s3.NewBucketLifecycleConfigurationV2(ctx, flowLogName, &s3.BucketLifecycleConfigurationV2Args{
+			Bucket: bucket.ID(),
+			Rules:  s3.BucketLifecycleConfigurationV2RuleArray{rule},
+		})
+		if err != nil {
+			return err
+		}
+
+		flowLogArgs.LogDestinationType = pulumi.String("s3")
+		flowLogArgs.LogDestination = bucket.Arn
+	}
+
+	_, err := ec2.NewFlowLog(ctx, flowLogName, flowLogArgs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package vpc
+
+import (
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// networkAclRule is a NACL rule whose CIDR may only be known once the VPC exists
+type networkAclRule struct {
+	ruleNo        int
+	egress        bool
+	protocol      string
+	action        string
+	cidrBlock     pulumi.StringPtrInput
+	ipv6CidrBlock pulumi.StringPtrInput
+	fromPort      int
+	toPort        int
+}
+
+// createNetworkAcls creates one network ACL per subnet tier and associates every subnet with the ACL of its tier.
+// Tiers without configured rules get the defaults from defaultNetworkAclRules.
+func createNetworkAcls(ctx *pulumi.Context, cfg *stackconfig.StackConfig, vpc *ec2.Vpc, subnetsByTier map[stackconfig.Tier][]subnet) error {
+	for _, tier := range []stackconfig.Tier{stackconfig.TierPublic, stackconfig.TierPrivate, stackconfig.TierIsolated} {
+		subnets := subnetsByTier[tier]
+		if len(subnets) == 0 {
+			continue
+		}
+
+		rules := defaultNetworkAclRules(cfg, vpc, tier)
+		if configured, ok := cfg.Network.NetworkAcls[tier]; ok {
+			rules = []networkAclRule{}
+			for _, rule := range configured {
+				rules = append(rules, configuredNetworkAclRule(rule))
+			}
+		}
+
+		ingress := ec2.NetworkAclIngressArray{}
+		egress := ec2.NetworkAclEgressArray{}
+		for _, rule := range rules {
+			if rule.egress {
+				egress = append(egress, ec2.NetworkAclEgressArgs{
+					RuleNo:        pulumi.Int(rule.ruleNo),
+					Protocol:      pulumi.String(rule.protocol),
+					Action:        pulumi.String(rule.action),
+					CidrBlock:     rule.cidrBlock,
+					Ipv6CidrBlock: rule.ipv6CidrBlock,
+					FromPort:      pulumi.Int(rule.fromPort),
+					ToPort:        pulumi.Int(rule.toPort),
+				})
+			} else {
+				ingress = append(ingress, ec2.NetworkAclIngressArgs{
+					RuleNo:        pulumi.Int(rule.ruleNo),
+					Protocol:      pulumi.String(rule.protocol),
+					Action:        pulumi.String(rule.action),
+					CidrBlock:     rule.cidrBlock,
+					Ipv6CidrBlock: rule.ipv6CidrBlock,
+					FromPort:      pulumi.Int(rule.fromPort),
+					ToPort:        pulumi.Int(rule.toPort),
+				})
+			}
+		}
+
+		naclName := cfg.Namer.Name("nacl", string(tier))
+		_, err := ec2.NewNetworkAcl(ctx, naclName, &ec2.NetworkAclArgs{
+			VpcId:     vpc.ID(),
+			SubnetIds: subnetIds(subnets),
+			Ingress:   ingress,
+			Egress:    egress,
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(naclName),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// defaultNetworkAclRules returns the rules of a tier that has none configured. Everything not allowed is denied.
+//   - public: HTTP and HTTPS from anywhere, return traffic on ephemeral ports and anything from inside the VPC,
+//     which the NAT needs to forward traffic from private subnets
+//   - private: anything from inside the VPC and return traffic on ephemeral ports, but no new connections from the internet
+//   - isolated: traffic to and from the VPC only
+func defaultNetworkAclRules(cfg *stackconfig.StackConfig, vpc *ec2.Vpc, tier stackconfig.Tier) []networkAclRule {
+	rules := []networkAclRule{}
+
+	// allow adds an IPv4 rule and, on dual-stack VPCs, its IPv6 counterpart at ruleNo+1
+	allow := func(ruleNo int, egress bool, protocol string, fromPort, toPort int, fromVpc bool) {
+		rule := networkAclRule{ruleNo: ruleNo, egress: egress, protocol: protocol, action: "allow", fromPort: fromPort, toPort: toPort}
+
+		rule.cidrBlock = pulumi.String("0.0.0.0/0")
+		if fromVpc {
+			rule.cidrBlock = pulumi.String(cfg.Network.VpcCidr)
+		}
+		rules = append(rules, rule)
+
+		if cfg.Network.Ipv6 {
+			rule.ruleNo = ruleNo + 1
+			rule.cidrBlock = nil
+			rule.ipv6CidrBlock = pulumi.String("::/0")
+			if fromVpc {
+				rule.ipv6CidrBlock = vpc.Ipv6CidrBlock
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	switch tier {
+	case stackconfig.TierPublic:
+		allow(100, false, "tcp", 80, 80, false)
+		allow(110, false, "tcp", 443, 443, false)
+		allow(120, false, "tcp", 1024, 65535, false)
+		allow(130, false, "udp", 1024, 65535, false)
+		allow(140, false, "-1", 0, 0, true)
+		allow(100, true, "-1", 0, 0, false)
+	case stackconfig.TierPrivate:
+		allow(100, false, "-1", 0, 0, true)
+		allow(110, false, "tcp", 1024, 65535, false)
+		allow(120, false, "udp", 1024, 65535, false)
+		allow(100, true, "-1", 0, 0, false)
+	case stackconfig.TierIsolated:
+		allow(100, false, "-1", 0, 0, true)
+		allow(100, true, "-1", 0, 0, true)
+	}
+
+	return rules
+}
+
+func configuredNetworkAclRule(rule stackconfig.NetworkAclRule) networkAclRule {
+	converted := networkAclRule{
+		ruleNo:   rule.RuleNo,
+		egress:   rule.Egress,
+		protocol: rule.Protocol,
+		action:   rule.Action,
+		fromPort: rule.FromPort,
+		toPort:   rule.ToPort,
+	}
+
+	if rule.CidrBlock != "" {
+		converted.cidrBlock = pulumi.String(rule.CidrBlock)
+	}
+	if rule.Ipv6CidrBlock != "" {
+		converted.ipv6CidrBlock = pulumi.String(rule.Ipv6CidrBlock)
+	}
+
+	return converted
+}
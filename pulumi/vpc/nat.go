@@ -0,0 +1,59 @@
+package vpc
+
+import (
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// createNatGateways creates the NAT gateways for the configured NAT mode, keyed by the AZ they are placed in.
+// Single mode puts one gateway in the first public subnet, per-az mode one in the first public subnet of every AZ.
+func createNatGateways(ctx *pulumi.Context, cfg *stackconfig.StackConfig, publicSubnets []subnet, igw *ec2.InternetGateway) (map[string]*ec2.NatGateway, error) {
+	natGwName := cfg.Namer.Name("nat")
+	natGateways := map[string]*ec2.NatGateway{}
+
+	switch cfg.Network.NatMode {
+	case stackconfig.NatModeSingle:
+		ngw, err := createNatGateway(ctx, natGwName, publicSubnets[0].id, igw)
+		if err != nil {
+			return nil, err
+		}
+		natGateways[publicSubnets[0].az] = ngw
+
+	case stackconfig.NatModePerAZ:
+		for _, publicSubnet := range publicSubnets {
+			if _, ok := natGateways[publicSubnet.az]; ok {
+				continue
+			}
+
+			ngw, err := createNatGateway(ctx, cfg.Namer.Name("nat", publicSubnet.az), publicSubnet.id, igw)
+			if err != nil {
+				return nil, err
+			}
+			natGateways[publicSubnet.az] = ngw
+		}
+	}
+
+	return natGateways, nil
+}
+
+func createNatGateway(ctx *pulumi.Context, natGwName string, subnetId pulumi.StringInput, igw *ec2.InternetGateway) (*ec2.NatGateway, error) {
+	eip, err := ec2.NewEip(ctx, natGwName, &ec2.EipArgs{
+		Vpc: pulumi.Bool(true),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(natGwName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ec2.NewNatGateway(ctx, natGwName, &ec2.NatGatewayArgs{
+		AllocationId: eip.ID(),
+		SubnetId:     subnetId,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(natGwName),
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{igw}))
+}
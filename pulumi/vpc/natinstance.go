@@ -0,0 +1,173 @@
+package vpc
+
+import (
+	"fmt"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Turns the instance into a router that masquerades traffic from the VPC behind its own address
+const natInstanceUserData = `#!/bin/bash
+set -euo pipefail
+
+yum install -y iptables-services
+
+echo "net.ipv4.ip_forward = 1" > /etc/sysctl.d/90-nat.conf
+sysctl -p /etc/sysctl.d/90-nat.conf
+
+IFACE=$(ip route show default | awk '{print $5}')
+iptables -t nat -A POSTROUTING -o "$IFACE" -s %s -j MASQUERADE
+iptables -F FORWARD
+service iptables save
+
+systemctl enable --now iptables
+`
+
+// createNatInstance launches a small EC2 instance in the first public subnet that private route tables can use
+// instead of a managed NAT gateway. A CloudWatch alarm recovers the instance when its host fails a status check.
+// Unless an AMI is pinned, the instance keeps the image it was launched with.
+func createNatInstance(ctx *pulumi.Context, cfg *stackconfig.StackConfig, vpc *ec2.Vpc, publicSubnet subnet, igw *ec2.InternetGateway) (*ec2.Instance, error) {
+	natGwName := cfg.Namer.Name("nat")
+	vpcCidr := cfg.Network.VpcCidr
+
+	amiId, err := natInstanceAmi(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	region, err := aws.GetRegion(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	partition, err := aws.GetPartition(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only accept traffic from inside the VPC
+	sgName := cfg.Namer.Name("nat", "sg")
+	sg, err := ec2.NewSecurityGroup(ctx, sgName, &ec2.SecurityGroupArgs{
+		VpcId: vpc.ID(),
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String(vpcCidr)},
+			},
+		},
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(sgName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A looked up image would replace the instance, and cut egress, whenever Amazon Linux is released
+	instanceOpts := []pulumi.ResourceOption{pulumi.DependsOn([]pulumi.Resource{igw})}
+	if cfg.Network.NatInstanceAmi == "" {
+		instanceOpts = append(instanceOpts, pulumi.IgnoreChanges([]string{"ami"}))
+	}
+
+	instance, err := ec2.NewInstance(ctx, natGwName, &ec2.InstanceArgs{
+		Ami:                     pulumi.String(amiId),
+		InstanceType:            pulumi.String(cfg.Network.NatInstanceType),
+		SubnetId:                publicSubnet.id,
+		VpcSecurityGroupIds:     pulumi.StringArray{sg.ID()},
+		SourceDestCheck:         pulumi.Bool(false),
+		UserData:                pulumi.String(fmt.Sprintf(natInstanceUserData, vpcCidr)),
+		UserDataReplaceOnChange: pulumi.Bool(true),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(natGwName),
+		},
+	}, instanceOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Give the instance a stable public address, like the NAT gateway it replaces
+	_, err = ec2.NewEip(ctx, natGwName, &ec2.EipArgs{
+		Vpc:      pulumi.Bool(true),
+		Instance: instance.ID(),
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(natGwName),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Recover the instance onto new hardware when the underlying host fails
+	_, err = cloudwatch.NewMetricAlarm(ctx, cfg.Namer.Name("nat", "recover"), &cloudwatch.MetricAlarmArgs{
+		AlarmDescription:   pulumi.String(fmt.Sprintf("Recover %s when its system status check fails", natGwName)),
+		Namespace:          pulumi.String("AWS/EC2"),
+		MetricName:         pulumi.String("StatusCheckFailed_System"),
+		Statistic:          pulumi.String("Maximum"),
+		Period:             pulumi.Int(60),
+		EvaluationPeriods:  pulumi.Int(2),
+		Threshold:          pulumi.Float64(0),
+		ComparisonOperator: pulumi.String("GreaterThanThreshold"),
+		Dimensions: pulumi.StringMap{
+			"InstanceId": instance.ID(),
+		},
+		AlarmActions: pulumi.Array{
+			pulumi.String(fmt.Sprintf("arn:%s:automate:%s:ec2:recover", partition.Partition, region.Name)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// natInstanceAmi returns the pinned AMI, or the latest Amazon Linux 2 image for the architecture of the
+// instance type, so Graviton types get the arm64 image
+func natInstanceAmi(ctx *pulumi.Context, cfg *stackconfig.StackConfig) (string, error) {
+	if cfg.Network.NatInstanceAmi != "" {
+		return cfg.Network.NatInstanceAmi, nil
+	}
+
+	instanceType, err := ec2.GetInstanceType(ctx, &ec2.GetInstanceTypeArgs{
+		InstanceType: cfg.Network.NatInstanceType,
+	})
+	if err != nil {
+		return "", err
+	}
+	architecture := "x86_64"
+	for _, supported := range instanceType.SupportedArchitectures {
+		if supported == "arm64" {
+			architecture = "arm64"
+		}
+	}
+
+	ami, err := ec2.LookupAmi(ctx, &ec2.LookupAmiArgs{
+		MostRecent: pulumi.BoolRef(true),
+		Owners:     []string{"amazon"},
+		Filters: []ec2.GetAmiFilter{
+			{
+				Name:   "name",
+				Values: []string{fmt.Sprintf("amzn2-ami-kernel-5.10-hvm-*-%s-gp2", architecture)},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return ami.Id, nil
+}
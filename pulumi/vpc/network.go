@@ -0,0 +1,160 @@
+package vpc
+
+import (
+	"fmt"
+	"sort"
+
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2transitgateway"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/route53"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Network is what the rest of the program gets to know about the VPC. Fields for optional parts
+// of the network are nil, or empty maps, when those parts are not configured.
+type Network struct {
+	Vpc                       *ec2.Vpc
+	InternetGateway           *ec2.InternetGateway
+	EgressOnlyInternetGateway *ec2.EgressOnlyInternetGateway
+
+	// Subnets holds the subnets of every tier keyed by AZ
+	Subnets map[stackconfig.Tier]map[string]*ec2.Subnet
+
+	PublicRouteTable *ec2.RouteTable
+	// PrivateRouteTables is keyed by AZ. Without per-AZ NAT every AZ points at the same route table.
+	PrivateRouteTables map[string]*ec2.RouteTable
+	IsolatedRouteTable *ec2.RouteTable
+
+	// NatGateways is keyed by the AZ the gateway is placed in
+	NatGateways map[string]*ec2.NatGateway
+	NatInstance *ec2.Instance
+
+	// Endpoints is keyed by service name, e.g. s3 or ecr.api
+	Endpoints map[string]*ec2.VpcEndpoint
+
+	// At most one of these connects the VPC to the remote network
+	VpcPeeringConnection     *ec2.VpcPeeringConnection
+	TransitGatewayAttachment *ec2transitgateway.VpcAttachment
+
+	// PrivateZone is the private hosted zone of the internal domain, where the cluster publishes its records
+	PrivateZone *route53.Zone
+}
+
+func newNetwork() *Network {
+	return &Network{
+		Subnets: map[stackconfig.Tier]map[string]*ec2.Subnet{
+			stackconfig.TierPublic:   {},
+			stackconfig.TierPrivate:  {},
+			stackconfig.TierIsolated: {},
+		},
+		PrivateRouteTables: map[string]*ec2.RouteTable{},
+		NatGateways:        map[string]*ec2.NatGateway{},
+		Endpoints:          map[string]*ec2.VpcEndpoint{},
+	}
+}
+
+// AZs returns the AZs that have a subnet in tier, sorted by name
+func (n *Network) AZs(tier stackconfig.Tier) []string {
+	azs := []string{}
+	for az := range n.Subnets[tier] {
+		azs = append(azs, az)
+	}
+	sort.Strings(azs)
+
+	return azs
+}
+
+// SubnetIds returns the IDs of the subnets in tier, sorted by AZ
+func (n *Network) SubnetIds(tier stackconfig.Tier) pulumi.StringArray {
+	ids := pulumi.StringArray{}
+	for _, az := range n.AZs(tier) {
+		ids = append(ids, n.Subnets[tier][az].ID())
+	}
+
+	return ids
+}
+
+// PlacementSubnetIds returns the IDs of the subnets in tier in azs, or in every AZ of tier if azs is empty
+func (n *Network) PlacementSubnetIds(tier stackconfig.Tier, azs []string) (pulumi.StringArray, error) {
+	if len(azs) == 0 {
+		azs = n.AZs(tier)
+	}
+
+	ids := pulumi.StringArray{}
+	for _, az := range azs {
+		subnet, ok := n.Subnets[tier][az]
+		if !ok {
+			return nil, fmt.Errorf("there is no %s subnet in %s", tier, az)
+		}
+		ids = append(ids, subnet.ID())
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("there are no %s subnets", tier)
+	}
+
+	return ids, nil
+}
+
+// export publishes the network as stack outputs
+func (n *Network) export(ctx *pulumi.Context) {
+	ctx.Export("vpcId", n.Vpc.ID())
+
+	if n.InternetGateway != nil {
+		ctx.Export("internetGatewayId", n.InternetGateway.ID())
+	}
+	if n.EgressOnlyInternetGateway != nil {
+		ctx.Export("egressOnlyInternetGatewayId", n.EgressOnlyInternetGateway.ID())
+	}
+
+	for tier, outputName := range map[stackconfig.Tier]string{
+		stackconfig.TierPublic:   "publicSubnetIds",
+		stackconfig.TierPrivate:  "privateSubnetIds",
+		stackconfig.TierIsolated: "isolatedSubnetIds",
+	} {
+		subnetIds := pulumi.StringMap{}
+		for az, subnet := range n.Subnets[tier] {
+			subnetIds[az] = subnet.ID()
+		}
+		ctx.Export(outputName, subnetIds)
+	}
+
+	if n.PublicRouteTable != nil {
+		ctx.Export("publicRouteTableId", n.PublicRouteTable.ID())
+	}
+	privateRouteTableIds := pulumi.StringMap{}
+	for az, routeTable := range n.PrivateRouteTables {
+		privateRouteTableIds[az] = routeTable.ID()
+	}
+	ctx.Export("privateRouteTableIds", privateRouteTableIds)
+	if n.IsolatedRouteTable != nil {
+		ctx.Export("isolatedRouteTableId", n.IsolatedRouteTable.ID())
+	}
+
+	natGatewayIds := pulumi.StringMap{}
+	for az, natGateway := range n.NatGateways {
+		natGatewayIds[az] = natGateway.ID()
+	}
+	ctx.Export("natGatewayIds", natGatewayIds)
+	if n.NatInstance != nil {
+		ctx.Export("natInstanceId", n.NatInstance.ID())
+	}
+
+	endpointIds := pulumi.StringMap{}
+	for service, endpoint := range n.Endpoints {
+		endpointIds[service] = endpoint.ID()
+	}
+	ctx.Export("vpcEndpointIds", endpointIds)
+
+	if n.VpcPeeringConnection != nil {
+		ctx.Export("vpcPeeringConnectionId", n.VpcPeeringConnection.ID())
+	}
+	if n.TransitGatewayAttachment != nil {
+		ctx.Export("transitGatewayAttachmentId", n.TransitGatewayAttachment.ID())
+	}
+
+	if n.PrivateZone != nil {
+		ctx.Export("privateZoneId", n.PrivateZone.ZoneId)
+	}
+}
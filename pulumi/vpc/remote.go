@@ -0,0 +1,73 @@
+package vpc
+
+import (
+	"uptactics/stackconfig"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2transitgateway"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// connectRemoteNetwork peers the VPC with the remote VPC or attaches it to the transit gateway, and returns
+// the routes to the remote CIDRs for the private and public route tables. The Transit Gateway attachment
+// is placed in the private subnets of the network, so those have to exist already.
+func connectRemoteNetwork(ctx *pulumi.Context, cfg *stackconfig.StackConfig, vpc *ec2.Vpc, network *Network) (ec2.RouteTableRouteArray, error) {
+	remote := cfg.Network.RemoteNetwork
+	routes := ec2.RouteTableRouteArray{}
+
+	switch remote.Type {
+	case stackconfig.RemoteNetworkPeering:
+		pcxName := cfg.Namer.Name("pcx")
+		pcxArgs := &ec2.VpcPeeringConnectionArgs{
+			VpcId:     vpc.ID(),
+			PeerVpcId: pulumi.String(remote.PeerVpcId),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(pcxName),
+			},
+		}
+
+		// Peering within the account and region can be accepted right away, otherwise the
+		// owner of the peer VPC has to accept it
+		if remote.PeerOwnerId == "" && remote.PeerRegion == "" {
+			pcxArgs.AutoAccept = pulumi.Bool(true)
+		}
+		if remote.PeerOwnerId != "" {
+			pcxArgs.PeerOwnerId = pulumi.String(remote.PeerOwnerId)
+		}
+		if remote.PeerRegion != "" {
+			pcxArgs.PeerRegion = pulumi.String(remote.PeerRegion)
+		}
+
+		pcx, err := ec2.NewVpcPeeringConnection(ctx, pcxName, pcxArgs)
+		if err != nil {
+			return nil, err
+		}
+		network.VpcPeeringConnection = pcx
+
+		for _, remoteCidr := range remote.Cidrs {
+			routes = append(routes, &ec2.RouteTableRouteArgs{CidrBlock: pulumi.String(remoteCidr), VpcPeeringConnectionId: pcx.ID()})
+		}
+
+	case stackconfig.RemoteNetworkTransitGateway:
+		attachmentName := cfg.Namer.Name("tgw", "attachment")
+		attachment, err := ec2transitgateway.NewVpcAttachment(ctx, attachmentName, &ec2transitgateway.VpcAttachmentArgs{
+			TransitGatewayId: pulumi.String(remote.TransitGatewayId),
+			VpcId:            vpc.ID(),
+			SubnetIds:        network.SubnetIds(stackconfig.TierPrivate),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(attachmentName),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		network.TransitGatewayAttachment = attachment
+
+		// Taking the gateway ID from the attachment keeps the routes from being created before it
+		for _, remoteCidr := range remote.Cidrs {
+			routes = append(routes, &ec2.RouteTableRouteArgs{CidrBlock: pulumi.String(remoteCidr), TransitGatewayId: attachment.TransitGatewayId})
+		}
+	}
+
+	return routes, nil
+}
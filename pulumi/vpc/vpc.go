@@ -2,33 +2,70 @@ package vpc
 
 import (
 	"fmt"
-	"strings"
+	"strconv"
+
+	"uptactics/cidr"
+	"uptactics/naming"
+	"uptactics/stackconfig"
 
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
-func CreateInfrastructure(ctx *pulumi.Context) (pulumi.StringInput, []pulumi.StringInput, []pulumi.StringInput, error) {
-	conf := config.New(ctx, "")
-	vpcName := conf.Require("vpcName")
-	vpcCidr := conf.Require("vpcCidr")
+type subnet struct {
+	az string
+	id pulumi.StringInput
+}
+
+// CreateInfrastructure creates the VPC and everything in it, or reads the existing VPC when one
+// is configured, publishing the result as stack outputs
+func CreateInfrastructure(ctx *pulumi.Context, cfg *stackconfig.StackConfig) (*Network, error) {
+	if cfg.Network.ExistingVpc != nil {
+		return importNetwork(ctx, cfg)
+	}
+
+	network := newNetwork()
+	namer := cfg.Namer
+	vpcName := namer.Name("vpc")
+	vpcCidr := cfg.Network.VpcCidr
 
 	// Creates the VPC
-	vpc, err := ec2.NewVpc(ctx, vpcName, &ec2.VpcArgs{
+	vpcArgs := &ec2.VpcArgs{
 		CidrBlock:          pulumi.String(vpcCidr),
 		EnableDnsHostnames: pulumi.Bool(true),
 		EnableDnsSupport:   pulumi.Bool(true),
 		Tags: pulumi.StringMap{
 			"Name": pulumi.String(vpcName),
 		},
-	})
+	}
+	if cfg.Network.Ipv6 {
+		vpcArgs.AssignGeneratedIpv6CidrBlock = pulumi.Bool(true)
+	}
+
+	vpc, err := ec2.NewVpc(ctx, vpcName, vpcArgs)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
+	}
+	network.Vpc = vpc
+
+	// Create Flow Logs
+	if cfg.Network.FlowLogs != nil {
+		err = createFlowLogs(ctx, cfg, vpc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create Private DNS
+	if cfg.Network.PrivateDns != nil {
+		network.PrivateZone, err = createPrivateDns(ctx, cfg, vpc)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Create IGW
-	igwName := conf.Require("igwName")
+	igwName := namer.Name("igw")
 
 	igw, err := ec2.NewInternetGateway(ctx, igwName, &ec2.InternetGatewayArgs{
 		VpcId: vpc.ID(),
@@ -37,135 +74,318 @@ func CreateInfrastructure(ctx *pulumi.Context) (pulumi.StringInput, []pulumi.Str
 		},
 	})
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
+	}
+	network.InternetGateway = igw
+
+	// Create Egress-only IGW so private subnets can reach the internet over IPv6 without being reachable from it.
+	// With natMode none, or without private subnets, there is nothing for it to route.
+	hasPrivateSubnets := cfg.Network.HasTier(stackconfig.TierPrivate)
+	var eigw *ec2.EgressOnlyInternetGateway
+	if cfg.Network.Ipv6 && cfg.Network.NatMode != stackconfig.NatModeNone && hasPrivateSubnets {
+		eigwName := namer.Name("igw", "egress-only")
+		eigw, err = ec2.NewEgressOnlyInternetGateway(ctx, eigwName, &ec2.EgressOnlyInternetGatewayArgs{
+			VpcId: vpc.ID(),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(eigwName),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		network.EgressOnlyInternetGateway = eigw
+	}
+	if cfg.Network.Ipv6 {
+		ctx.Export("vpcIpv6Cidr", vpc.Ipv6CidrBlock)
 	}
 
 	// Create Subnets
-	clusterName := conf.Require("clusterName")
-	subnetNamesString := conf.Require("subnetNames")
-	subnetCidrsString := conf.Require("subnetCidrs")
-	subnetAZsString := conf.Require("subnetAZs")
+	clusterName := cfg.Cluster.Name
 
-	subnetNames := strings.Split(subnetNamesString, ",")
-	subnetCidrs := strings.Split(subnetCidrsString, ",")
-	subnetAZs := strings.Split(subnetAZsString, ",")
+	// Export the subnet plan so computed CIDRs can be reviewed in `pulumi preview`
+	subnetPlan := pulumi.Array{}
+	for _, subnetConfig := range cfg.Network.Subnets {
+		subnetPlan = append(subnetPlan, pulumi.StringMap{
+			"name": pulumi.String(subnetConfig.Name),
+			"cidr": pulumi.String(subnetConfig.Cidr),
+			"az":   pulumi.String(subnetConfig.AZ),
+			"tier": pulumi.String(string(subnetConfig.Tier)),
+		})
+	}
+	ctx.Export("subnetPlan", subnetPlan)
 
-	publicSubnetIds := []pulumi.StringInput{}
-	privateSubnetIds := []pulumi.StringInput{}
+	publicSubnets := []subnet{}
+	privateSubnets := []subnet{}
+	isolatedSubnets := []subnet{}
+	subnetIpv6Cidrs := pulumi.Map{}
 
-	for i, subnetName := range subnetNames {
-		subnetCidr := subnetCidrs[i]
-		subnetAZ := subnetAZs[i]
-		isPrivate := strings.Contains(subnetName, "private")
+	for _, subnetConfig := range cfg.Network.Subnets {
+		subnetName := subnetConfig.Name
 
-		tags := pulumi.StringMap{
-			"Name": pulumi.String(subnetName),
-			fmt.Sprintf("kubernetes.io/cluster/%s", clusterName): pulumi.String("owned"),
+		tags := pulumi.StringMap{}
+		for key, value := range subnetConfig.Tags {
+			tags[key] = pulumi.String(value)
 		}
+		tags["Name"] = pulumi.String(subnetName)
+		tags[fmt.Sprintf("kubernetes.io/cluster/%s", clusterName)] = pulumi.String("owned")
 
-		if isPrivate {
-			tags["kubernetes.io/role/internal-elb"] = pulumi.String("1")
-		} else {
+		switch subnetConfig.Tier {
+		case stackconfig.TierPublic:
 			tags["kubernetes.io/role/elb"] = pulumi.String("1")
+		case stackconfig.TierPrivate:
+			tags["kubernetes.io/role/internal-elb"] = pulumi.String("1")
 		}
 
-		subnet, err := ec2.NewSubnet(ctx, subnetName, &ec2.SubnetArgs{
-			VpcId:            vpc.ID(),
-			CidrBlock:        pulumi.String(subnetCidr),
-			AvailabilityZone: pulumi.String(subnetAZ),
-			Tags:             tags,
+		subnetArgs := &ec2.SubnetArgs{
+			VpcId:               vpc.ID(),
+			CidrBlock:           pulumi.String(subnetConfig.Cidr),
+			AvailabilityZone:    pulumi.String(subnetConfig.AZ),
+			MapPublicIpOnLaunch: pulumi.Bool(subnetConfig.MapPublicIpOnLaunch),
+			Tags:                tags,
+		}
+
+		// Every subnet takes the /64 of its tier and AZ
+		if cfg.Network.Ipv6 {
+			ipv6Index := cfg.Network.Ipv6SubnetIndex(subnetConfig)
+			subnetArgs.Ipv6CidrBlock = vpc.Ipv6CidrBlock.ApplyT(func(block string) (string, error) {
+				return cidr.Ipv6Subnet(block, ipv6Index)
+			}).(pulumi.StringOutput)
+			subnetArgs.AssignIpv6AddressOnCreation = pulumi.Bool(true)
+		}
+
+		ec2Subnet, err := ec2.NewSubnet(ctx, subnetName, subnetArgs, naming.Aliases(subnetConfig.LegacyName))
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Network.Ipv6 {
+			subnetIpv6Cidrs[subnetName] = ec2Subnet.Ipv6CidrBlock
+		}
+
+		network.Subnets[subnetConfig.Tier][subnetConfig.AZ] = ec2Subnet
+
+		created := subnet{az: subnetConfig.AZ, id: ec2Subnet.ID()}
+		switch subnetConfig.Tier {
+		case stackconfig.TierPublic:
+			publicSubnets = append(publicSubnets, created)
+		case stackconfig.TierPrivate:
+			privateSubnets = append(privateSubnets, created)
+		case stackconfig.TierIsolated:
+			isolatedSubnets = append(isolatedSubnets, created)
+		}
+	}
+
+	if cfg.Network.Ipv6 {
+		ctx.Export("subnetIpv6Cidrs", subnetIpv6Cidrs)
+	}
+
+	// Create Network ACLs
+	if cfg.Network.NetworkAcls != nil {
+		err = createNetworkAcls(ctx, cfg, vpc, map[stackconfig.Tier][]subnet{
+			stackconfig.TierPublic:   publicSubnets,
+			stackconfig.TierPrivate:  privateSubnets,
+			stackconfig.TierIsolated: isolatedSubnets,
 		})
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
+	}
 
-		if isPrivate {
-			privateSubnetIds = append(privateSubnetIds, subnet.ID())
-		} else {
-			publicSubnetIds = append(publicSubnetIds, subnet.ID())
+	// Connect the remote network
+	remoteRoutes := ec2.RouteTableRouteArray{}
+	if cfg.Network.RemoteNetwork != nil {
+		remoteRoutes, err = connectRemoteNetwork(ctx, cfg, vpc, network)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// Create NAT Gateway
-	natGwName := conf.Require("natGwName")
+	// Create NAT Gateways or the NAT instance, only when there are private subnets to route
+	natGateways := map[string]*ec2.NatGateway{}
+	if hasPrivateSubnets {
+		natGateways, err = createNatGateways(ctx, cfg, publicSubnets, igw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	network.NatGateways = natGateways
 
-	eip, err := ec2.NewEip(ctx, natGwName, &ec2.EipArgs{
-		Vpc: pulumi.Bool(true),
-		Tags: pulumi.StringMap{
-			"Name": pulumi.String(natGwName),
-		},
-	})
-	if err != nil {
-		return nil, nil, nil, err
+	var natInstance *ec2.Instance
+	if hasPrivateSubnets && cfg.Network.NatMode == stackconfig.NatModeInstance {
+		natInstance, err = createNatInstance(ctx, cfg, vpc, publicSubnets[0], igw)
+		if err != nil {
+			return nil, err
+		}
+		network.NatInstance = natInstance
 	}
 
-	ngw, err := ec2.NewNatGateway(ctx, natGwName, &ec2.NatGatewayArgs{
-		AllocationId: eip.ID(),
-		SubnetId:     publicSubnetIds[0],
-		Tags: pulumi.StringMap{
-			"Name": pulumi.String(natGwName),
-		},
-	}, pulumi.DependsOn([]pulumi.Resource{igw}))
-	if err != nil {
-		return nil, nil, nil, err
+	// privateRoutes returns the routes out of the VPC for private subnets in az
+	privateRoutes := func(az string) ec2.RouteTableRouteArray {
+		routes := append(ec2.RouteTableRouteArray{}, remoteRoutes...)
+
+		switch cfg.Network.NatMode {
+		case stackconfig.NatModeSingle:
+			for _, ngw := range natGateways {
+				routes = append(routes, &ec2.RouteTableRouteArgs{CidrBlock: pulumi.String("0.0.0.0/0"), NatGatewayId: ngw.ID()})
+			}
+		case stackconfig.NatModePerAZ:
+			routes = append(routes, &ec2.RouteTableRouteArgs{CidrBlock: pulumi.String("0.0.0.0/0"), NatGatewayId: natGateways[az].ID()})
+		case stackconfig.NatModeInstance:
+			routes = append(routes, &ec2.RouteTableRouteArgs{CidrBlock: pulumi.String("0.0.0.0/0"), NetworkInterfaceId: natInstance.PrimaryNetworkInterfaceId})
+		}
+
+		if eigw != nil {
+			routes = append(routes, &ec2.RouteTableRouteArgs{Ipv6CidrBlock: pulumi.String("::/0"), EgressOnlyGatewayId: eigw.ID()})
+		}
+
+		return routes
 	}
 
 	// Route Tables
-	privateRTName := conf.Require("privateRTName")
-	privateRT, err := ec2.NewRouteTable(ctx, privateRTName, &ec2.RouteTableArgs{
-		VpcId: vpc.ID(),
-		Routes: ec2.RouteTableRouteArray{
-			&ec2.RouteTableRouteArgs{
-				CidrBlock:    pulumi.String("0.0.0.0/0"),
-				NatGatewayId: ngw.ID(),
-			},
-		},
-		Tags: pulumi.StringMap{
-			"Name": pulumi.String(privateRTName),
+	// In per-az mode every AZ gets a private route table pointing at its local NAT gateway,
+	// otherwise every private subnet shares one. Without private subnets there is none.
+	privateRTName := namer.Name("rt", "private")
+	privateRTs := network.PrivateRouteTables
+
+	switch {
+	case !hasPrivateSubnets:
+		// Nothing to route
+	case cfg.Network.NatMode == stackconfig.NatModePerAZ:
+		for _, az := range cfg.Network.AZs(stackconfig.TierPrivate) {
+			privateRT, err := createPrivateRouteTable(ctx, vpc, fmt.Sprintf("%s-%s", privateRTName, az), privateRoutes(az))
+			if err != nil {
+				return nil, err
+			}
+			privateRTs[az] = privateRT
+		}
+	default:
+		privateRT, err := createPrivateRouteTable(ctx, vpc, privateRTName, privateRoutes(""))
+		if err != nil {
+			return nil, err
+		}
+		for _, az := range cfg.Network.AZs(stackconfig.TierPrivate) {
+			privateRTs[az] = privateRT
+		}
+	}
+
+	publicRoutes := ec2.RouteTableRouteArray{
+		&ec2.RouteTableRouteArgs{
+			CidrBlock: pulumi.String("0.0.0.0/0"),
+			GatewayId: igw.ID(),
 		},
-	})
-	if err != nil {
-		return nil, nil, nil, err
 	}
+	if cfg.Network.Ipv6 {
+		publicRoutes = append(publicRoutes, &ec2.RouteTableRouteArgs{
+			Ipv6CidrBlock: pulumi.String("::/0"),
+			GatewayId:     igw.ID(),
+		})
+	}
+	publicRoutes = append(publicRoutes, remoteRoutes...)
 
-	publicRTName := conf.Require("publicRTName")
+	publicRTName := namer.Name("rt", "public")
 	publicRT, err := ec2.NewRouteTable(ctx, publicRTName, &ec2.RouteTableArgs{
-		VpcId: vpc.ID(),
-		Routes: ec2.RouteTableRouteArray{
-			&ec2.RouteTableRouteArgs{
-				CidrBlock: pulumi.String("0.0.0.0/0"),
-				GatewayId: igw.ID(),
-			},
-		},
+		VpcId:  vpc.ID(),
+		Routes: publicRoutes,
 		Tags: pulumi.StringMap{
 			"Name": pulumi.String(publicRTName),
 		},
 	})
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
+	}
+	network.PublicRouteTable = publicRT
+
+	// Isolated subnets get a route table without a default route so they can only reach the VPC
+	var isolatedRT *ec2.RouteTable
+	if len(isolatedSubnets) > 0 {
+		isolatedRTName := namer.Name("rt", "isolated")
+		isolatedRT, err = ec2.NewRouteTable(ctx, isolatedRTName, &ec2.RouteTableArgs{
+			VpcId: vpc.ID(),
+			Tags: pulumi.StringMap{
+				"Name": pulumi.String(isolatedRTName),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		network.IsolatedRouteTable = isolatedRT
 	}
 
-	for i, privateSubnetId := range privateSubnetIds {
-		rtaName := fmt.Sprintf("u-staging-rta-private-%d", i+1)
+	for _, isolatedSubnet := range isolatedSubnets {
+		rtaName := namer.Name("rta", "isolated", isolatedSubnet.az)
 		_, err := ec2.NewRouteTableAssociation(ctx, rtaName, &ec2.RouteTableAssociationArgs{
-			SubnetId:     privateSubnetId,
-			RouteTableId: privateRT.ID(),
+			SubnetId:     isolatedSubnet.id,
+			RouteTableId: isolatedRT.ID(),
 		})
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
+		}
+	}
+
+	// Associations used to be named rta-<tier>-<n> by position. The aliases keep the existing
+	// associations now that they are named by AZ.
+	for i, privateSubnet := range privateSubnets {
+		rtaName := namer.Name("rta", "private", privateSubnet.az)
+		_, err := ec2.NewRouteTableAssociation(ctx, rtaName, &ec2.RouteTableAssociationArgs{
+			SubnetId:     privateSubnet.id,
+			RouteTableId: privateRTs[privateSubnet.az].ID(),
+		}, naming.Aliases(namer.Name("rta", "private", strconv.Itoa(i+1))))
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	for i, publicSubnetId := range publicSubnetIds {
-		rtaName := fmt.Sprintf("u-staging-rta-public-%d", i+1)
+	for i, publicSubnet := range publicSubnets {
+		rtaName := namer.Name("rta", "public", publicSubnet.az)
 		_, err := ec2.NewRouteTableAssociation(ctx, rtaName, &ec2.RouteTableAssociationArgs{
-			SubnetId:     publicSubnetId,
+			SubnetId:     publicSubnet.id,
 			RouteTableId: publicRT.ID(),
-		})
+		}, naming.Aliases(namer.Name("rta", "public", strconv.Itoa(i+1))))
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
+		}
+	}
+
+	// Create VPC Endpoints, with the S3 gateway on every route table that doesn't go through the IGW
+	if cfg.Network.Endpoints != nil {
+		endpointRTs := []*ec2.RouteTable{}
+		seenRTs := map[*ec2.RouteTable]bool{}
+		for _, az := range cfg.Network.AZs(stackconfig.TierPrivate) {
+			if !seenRTs[privateRTs[az]] {
+				endpointRTs = append(endpointRTs, privateRTs[az])
+				seenRTs[privateRTs[az]] = true
+			}
+		}
+		if isolatedRT != nil {
+			endpointRTs = append(endpointRTs, isolatedRT)
 		}
+
+		network.Endpoints, err = createEndpoints(ctx, cfg, vpc, privateSubnets, endpointRTs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	network.export(ctx)
+
+	return network, nil
+}
+
+func createPrivateRouteTable(ctx *pulumi.Context, vpc *ec2.Vpc, name string, routes ec2.RouteTableRouteArray) (*ec2.RouteTable, error) {
+	return ec2.NewRouteTable(ctx, name, &ec2.RouteTableArgs{
+		VpcId:  vpc.ID(),
+		Routes: routes,
+		Tags: pulumi.StringMap{
+			"Name": pulumi.String(name),
+		},
+	})
+}
+
+func subnetIds(subnets []subnet) pulumi.StringArray {
+	ids := pulumi.StringArray{}
+	for _, subnet := range subnets {
+		ids = append(ids, subnet.id)
 	}
 
-	return vpc.ID(), privateSubnetIds, publicSubnetIds, nil
+	return ids
 }
@@ -13,6 +13,7 @@ resource "aws_subnet" "private-subnet-1" {
 
   tags = {
     Name = "${local.service_name_env}-private-subnet-1"
+    Tier = "private"
   }
 }
 
@@ -23,6 +24,7 @@ resource "aws_subnet" "private-subnet-2" {
 
   tags = {
     Name = "${local.service_name_env}-private-subnet-2"
+    Tier = "private"
   }
 }
 
@@ -33,5 +35,6 @@ resource "aws_subnet" "public-subnet" {
 
   tags = {
     Name = "${local.service_name_env}-public-subnet"
+    Tier = "public"
   }
 }